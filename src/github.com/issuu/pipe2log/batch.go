@@ -0,0 +1,312 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/http"
+    url "net/url"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Destinations sending over http collect messages in batches, which are
+// sent from a background goroutine when the batch is full or at the
+// latest every interval. Failed batches are retried with an exponential
+// backoff. If the destination can't keep up, the queue fills up and new
+// messages are dropped instead of blocking the input.
+
+type batchOptions struct {
+    maxCount int
+    maxBytes int
+    interval time.Duration
+    queueSize int
+    retries int
+}
+
+// the options handled by parseBatchOptions
+var batchOptionNames = []string{"batch", "batchbytes", "interval", "queue", "retries"}
+
+func parseBatchOptions(spec string, options url.Values) (batchOptions, error) {
+    o := batchOptions{maxCount: 500, maxBytes: 1024 * 1024, interval: time.Second, queueSize: 10000, retries: 3}
+    var err error
+    for _, name := range batchOptionNames {
+        v := options.Get(name)
+        if v == "" {
+            continue
+        }
+        var size int64
+        switch name {
+        case "batch":
+            o.maxCount, err = strconv.Atoi(v)
+            if err == nil && o.maxCount < 1 {
+                err = fmt.Errorf("must be positive")
+            }
+        case "batchbytes":
+            size, err = parseSize(v)
+            o.maxBytes = int(size)
+            if err == nil && o.maxBytes < 1 {
+                err = fmt.Errorf("must be positive")
+            }
+        case "interval":
+            o.interval, err = time.ParseDuration(v)
+            if err == nil && o.interval <= 0 {
+                err = fmt.Errorf("must be positive")
+            }
+        case "queue":
+            o.queueSize, err = strconv.Atoi(v)
+            if err == nil && o.queueSize < 1 {
+                err = fmt.Errorf("must be positive")
+            }
+        case "retries":
+            o.retries, err = strconv.Atoi(v)
+            if err == nil && o.retries < 0 {
+                err = fmt.Errorf("must not be negative")
+            }
+        }
+        if err != nil {
+            return o, fmt.Errorf("invalid %s '%s' for destination '%s': %s", name, v, spec, err)
+        }
+    }
+    return o, nil
+}
+
+// permanentError is an error not worth retrying, i.e. a rejected request
+type permanentError struct {
+    err error
+}
+
+func (e permanentError) Error() string {
+    return e.err.Error()
+}
+
+// retryItems is returned by a send that failed for part of the batch,
+// only the messages that failed are retried
+type retryItems struct {
+    messages []*logMessage
+    err error
+}
+
+func (e retryItems) Error() string {
+    return e.err.Error()
+}
+
+const maxBackoff = 30 * time.Second
+
+// withRetry calls send until it succeeds, returns a permanent error or
+// retries is exhausted.
+func withRetry(retries int, send func() error) error {
+    backoff := 500 * time.Millisecond
+    for attempt := 0; ; attempt++ {
+        err := send()
+        if err == nil {
+            return nil
+        }
+        if _, ok := err.(permanentError); ok || attempt >= retries {
+            return err
+        }
+        time.Sleep(backoff)
+        backoff *= 2
+        if backoff > maxBackoff {
+            backoff = maxBackoff
+        }
+    }
+}
+
+type batcher struct {
+    name string
+    options batchOptions
+    send func(batch []*logMessage) error
+    queue chan *logMessage
+    done chan struct{}
+    dropped int64
+}
+
+func newBatcher(name string, options batchOptions, send func(batch []*logMessage) error) *batcher {
+    b := &batcher{
+        // the name is logged
+        name: redactDestination(name),
+        options: options,
+        send: send,
+        queue: make(chan *logMessage, options.queueSize),
+        done: make(chan struct{}),
+    }
+    go b.run()
+    return b
+}
+
+// add queues m without blocking, m is dropped if the queue is full. The
+// hostname, appname and facility come from the configuration, which is
+// changed by a reload on the main goroutine, so they are looked up here
+// and not when the batch is sent. The procid is resolved with them, with
+// the appname set it no longer falls back to the pid.
+func (b *batcher) add(m *logMessage) {
+    queued := *m
+    queued.procid = m.procID()
+    queued.hostname = m.host()
+    queued.appname = m.app()
+    queued.facility = m.facilityName()
+    select {
+    case b.queue <- &queued:
+    default:
+        atomic.AddInt64(&b.dropped, 1)
+    }
+}
+
+func (b *batcher) flush(batch []*logMessage) {
+    if len(batch) == 0 {
+        return
+    }
+    pending := batch
+    err := withRetry(b.options.retries, func() error {
+        err := b.send(pending)
+        if r, ok := err.(retryItems); ok {
+            pending = r.messages
+        }
+        return err
+    })
+    if err != nil {
+        log.Printf("%s %s dropped %d messages: %s\n", appTagVersion, b.name, len(pending), err)
+    }
+    if dropped := atomic.SwapInt64(&b.dropped, 0); dropped > 0 {
+        log.Printf("%s %s queue full, dropped %d messages\n", appTagVersion, b.name, dropped)
+    }
+}
+
+func (b *batcher) run() {
+    defer close(b.done)
+    ticker := time.NewTicker(b.options.interval)
+    defer ticker.Stop()
+    var batch []*logMessage
+    size := 0
+    for {
+        select {
+        case m, ok := <- b.queue:
+            if !ok {
+                b.flush(batch)
+                return
+            }
+            batch = append(batch, m)
+            size += len(m.msg) + 64
+            if len(batch) >= b.options.maxCount || size >= b.options.maxBytes {
+                b.flush(batch)
+                batch = nil
+                size = 0
+            }
+        case <- ticker.C:
+            b.flush(batch)
+            batch = nil
+            size = 0
+        }
+    }
+}
+
+// close sends what is left in the queue
+func (b *batcher) close() {
+    close(b.queue)
+    <-b.done
+}
+
+// httpPoster posts request bodies, optionally gzipped, with extra headers
+type httpPoster struct {
+    url *url.URL
+    headers http.Header
+    gzip bool
+    client *http.Client
+}
+
+// the options handled by newHTTPPoster
+var httpOptionNames = []string{"header", "gzip", "timeout"}
+
+// newHTTPPoster takes its options out of options, the options left are
+// kept in the query of the url.
+func newHTTPPoster(spec, uri string, options url.Values, keep ...string) (*httpPoster, error) {
+    p := &httpPoster{headers: http.Header{}, client: &http.Client{Timeout: 30 * time.Second}}
+    var err error
+    for _, header := range options["header"] {
+        idx := strings.Index(header, ":")
+        if idx <= 0 {
+            // the header may be a secret
+            return nil, fmt.Errorf("invalid header for destination '%s', use Name:Value", spec)
+        }
+        p.headers.Add(strings.TrimSpace(header[:idx]), strings.TrimSpace(header[idx+1:]))
+    }
+    if v := options.Get("gzip"); v != "" {
+        if p.gzip, err = strconv.ParseBool(v); err != nil {
+            return nil, fmt.Errorf("invalid gzip '%s' for destination '%s'", v, spec)
+        }
+    }
+    if v := options.Get("timeout"); v != "" {
+        if p.client.Timeout, err = time.ParseDuration(v); err != nil {
+            return nil, fmt.Errorf("invalid timeout '%s' for destination '%s'", v, spec)
+        }
+    }
+
+    // everything that isn't ours goes to the server
+    query := url.Values{}
+    loop:for name, values := range options {
+        for _, ours := range append(append(append([]string{}, httpOptionNames...), batchOptionNames...), keep...) {
+            if name == ours {
+                continue loop
+            }
+        }
+        query[name] = values
+    }
+    u, err := url.Parse(uri)
+    if err != nil {
+        return nil, err
+    }
+    u.RawQuery = query.Encode()
+    p.url = u
+    return p, nil
+}
+
+// post returns the response body, errors for 4xx responses other than
+// 429 are permanent. path is appended to the path of the url.
+func (p *httpPoster) post(path string, body []byte, contentType string) ([]byte, error) {
+    u := *p.url
+    if path != "" {
+        u.Path = strings.TrimSuffix(u.Path, "/") + path
+    }
+    var reader io.Reader = bytes.NewReader(body)
+    if p.gzip {
+        var buf bytes.Buffer
+        z := gzip.NewWriter(&buf)
+        z.Write(body)
+        z.Close()
+        reader = &buf
+    }
+    req, err := http.NewRequest("POST", u.String(), reader)
+    if err != nil {
+        return nil, permanentError{err}
+    }
+    for name, values := range p.headers {
+        req.Header[name] = values
+    }
+    req.Header.Set("Content-Type", contentType)
+    if p.gzip {
+        req.Header.Set("Content-Encoding", "gzip")
+    }
+    resp, err := p.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    response, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode >= 300 {
+        err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(response))
+        if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+            return response, permanentError{err}
+        }
+        return response, err
+    }
+    return response, nil
+}
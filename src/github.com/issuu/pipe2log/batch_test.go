@@ -0,0 +1,135 @@
+package main
+
+import (
+    "errors"
+    url "net/url"
+    "os"
+    "strconv"
+    "testing"
+    "time"
+)
+
+func TestParseBatchOptions(t *testing.T) {
+    tests := []struct {
+        query string
+        want batchOptions
+        ok bool
+    }{
+        {"", batchOptions{maxCount: 500, maxBytes: 1024 * 1024, interval: time.Second, queueSize: 10000, retries: 3}, true},
+        {"batch=10&batchbytes=64K&interval=5s&queue=100&retries=0", batchOptions{maxCount: 10, maxBytes: 64 * 1024, interval: 5 * time.Second, queueSize: 100, retries: 0}, true},
+        {"batch=0", batchOptions{}, false},
+        {"batch=x", batchOptions{}, false},
+        {"batchbytes=0", batchOptions{}, false},
+        {"interval=0s", batchOptions{}, false},
+        {"queue=0", batchOptions{}, false},
+        {"retries=-1", batchOptions{}, false},
+    }
+    for _, test := range tests {
+        options, _ := url.ParseQuery(test.query)
+        got, err := parseBatchOptions("http://localhost?"+test.query, options)
+        if !test.ok {
+            if err == nil {
+                t.Errorf("%s: expected an error, got %+v", test.query, got)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %s", test.query, err)
+        } else if got != test.want {
+            t.Errorf("%s: got %+v, expected %+v", test.query, got, test.want)
+        }
+    }
+}
+
+func TestWithRetry(t *testing.T) {
+    tests := []struct {
+        name string
+        retries int
+        err error
+        calls int
+    }{
+        {"success", 2, nil, 1},
+        {"permanent error", 2, permanentError{errors.New("rejected")}, 1},
+        {"no retries", 0, errors.New("unavailable"), 1},
+        {"retries exhausted", 1, errors.New("unavailable"), 2},
+    }
+    for _, test := range tests {
+        calls := 0
+        err := withRetry(test.retries, func() error {
+            calls++
+            return test.err
+        })
+        if err != test.err {
+            t.Errorf("%s: got error %v, expected %v", test.name, err, test.err)
+        }
+        if calls != test.calls {
+            t.Errorf("%s: called %d times, expected %d", test.name, calls, test.calls)
+        }
+    }
+}
+
+func TestBatcherRetriesFailedItems(t *testing.T) {
+    batch := []*logMessage{{msg: "one"}, {msg: "two"}, {msg: "three"}}
+    var sent [][]*logMessage
+    b := &batcher{name: "test", options: batchOptions{retries: 3}, send: func(batch []*logMessage) error {
+        sent = append(sent, batch)
+        if len(sent) == 1 {
+            return retryItems{messages: batch[1:2], err: errors.New("one item failed")}
+        }
+        return nil
+    }}
+    b.flush(batch)
+    if len(sent) != 2 {
+        t.Fatalf("sent %d times, expected 2", len(sent))
+    }
+    if len(sent[0]) != 3 {
+        t.Errorf("first send got %d messages, expected 3", len(sent[0]))
+    }
+    if len(sent[1]) != 1 || sent[1][0].msg != "two" {
+        t.Errorf("retry got %v, expected only the failed message", sent[1])
+    }
+}
+
+func TestBatcherSplitsBatches(t *testing.T) {
+    var sent [][]string
+    options := batchOptions{maxCount: 2, maxBytes: 1024 * 1024, interval: time.Hour, queueSize: 10}
+    b := newBatcher("test", options, func(batch []*logMessage) error {
+        var msgs []string
+        for _, m := range batch {
+            msgs = append(msgs, m.msg)
+        }
+        sent = append(sent, msgs)
+        return nil
+    })
+    for _, msg := range []string{"one", "two", "three"} {
+        b.add(&logMessage{msg: msg})
+    }
+    b.close()
+    if len(sent) != 2 || len(sent[0]) != 2 || len(sent[1]) != 1 || sent[1][0] != "three" {
+        t.Errorf("got batches %v, expected [[one two] [three]]", sent)
+    }
+}
+
+func TestBatcherQueuesConfiguration(t *testing.T) {
+    defer func(appname, facility string) {
+        flagSyslogAppname, flagSyslogFacility = appname, facility
+    }(flagSyslogAppname, flagSyslogFacility)
+    flagSyslogAppname, flagSyslogFacility = "before", "local0"
+
+    var sent []*logMessage
+    b := newBatcher("test", batchOptions{maxCount: 10, maxBytes: 1024 * 1024, interval: time.Hour, queueSize: 10}, func(batch []*logMessage) error {
+        sent = append(sent, batch...)
+        return nil
+    })
+    b.add(&logMessage{msg: "queued"})
+    // a reload after the message was queued
+    flagSyslogAppname, flagSyslogFacility = "after", "local1"
+    b.close()
+    if len(sent) != 1 || sent[0].app() != "before" || sent[0].facilityName() != "local0" {
+        t.Errorf("got %+v, expected the appname and facility when queued", sent)
+    }
+    // without an appname of its own the message is from our parent process
+    if len(sent) == 1 && sent[0].procID() != strconv.Itoa(os.Getppid()) {
+        t.Errorf("got procid %q, expected %d", sent[0].procID(), os.Getppid())
+    }
+}
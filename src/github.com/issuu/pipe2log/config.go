@@ -0,0 +1,197 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+    "sync/atomic"
+)
+
+// Configuration is layered, the first one found wins:
+//   1. command line flags
+//   2. environment variables, PIPE2LOG_<FLAGNAME>, i.e. PIPE2LOG_SYSLOGURI
+//   3. the config file given with -config
+//   4. the flag defaults
+// Everything but the command line can be changed at runtime and is
+// re-read when pipe2log receives a SIGHUP.
+
+const envPrefix = "PIPE2LOG_"
+
+var flagConfigFile string
+
+// flags given on the command line, these are never overridden by a reload
+var cmdlineFlags map[string]bool
+
+// flags that only make sense on the command line
+var noReloadFlags = map[string]bool{
+    "config":  true,
+    "version": true,
+    "cmd":     true,
+    "dryrun":  true,
+    "ratesummary": true,
+}
+
+// current logformat as seen by the scanner goroutines, so a reload can
+// switch between line and json based scanning on the fly.
+var scanFormat atomic.Value
+
+func rememberCmdlineFlags() {
+    cmdlineFlags = make(map[string]bool)
+    flag.Visit(func(f *flag.Flag) {
+        cmdlineFlags[f.Name] = true
+    })
+}
+
+// parseConfigFile reads lines in the format 'name = value' or 'name value',
+// where name is a command line flag name without the dash. Empty lines
+// and lines starting with a '#' are ignored.
+func parseConfigFile(path string) (map[string]string, error) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    options := make(map[string]string)
+    s := bufio.NewScanner(bytes.NewReader(content))
+    lineno := 0
+    for s.Scan() {
+        lineno++
+        line := strings.TrimSpace(s.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        var name, value string
+        if idx := strings.IndexAny(line, "= \t"); idx > 0 {
+            name = strings.TrimSpace(line[:idx])
+            value = strings.TrimSpace(line[idx:])
+            value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+        } else {
+            // a boolean flag on its own
+            name = line
+            value = "true"
+        }
+        name = strings.TrimLeft(name, "-")
+        if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+            value = value[1:len(value)-1]
+        }
+        if flag.Lookup(name) == nil || noReloadFlags[name] {
+            return nil, fmt.Errorf("%s:%d unknown option '%s'", path, lineno, name)
+        }
+        options[name] = value
+    }
+    return options, s.Err()
+}
+
+func envName(flagName string) string {
+    return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// loadConfig resets all options not given on the command line to their
+// defaults and applies the config file and environment on top.
+func loadConfig() error {
+    var fileOptions map[string]string
+    var err error
+    if flagConfigFile != "" {
+        fileOptions, err = parseConfigFile(flagConfigFile)
+        if err != nil {
+            return err
+        }
+    }
+    flag.VisitAll(func(f *flag.Flag) {
+        if err != nil || cmdlineFlags[f.Name] || noReloadFlags[f.Name] {
+            return
+        }
+        value := f.DefValue
+        origin := "default"
+        if v, ok := fileOptions[f.Name]; ok {
+            value = v
+            origin = flagConfigFile
+        }
+        if v, ok := os.LookupEnv(envName(f.Name)); ok {
+            value = v
+            origin = envName(f.Name)
+        }
+        if e := f.Value.Set(value); e != nil {
+            err = fmt.Errorf("invalid value '%s' for option '%s' from %s: %s", value, f.Name, origin, e)
+        }
+    })
+    return err
+}
+
+// checkConfig validates option values that the flag package can't.
+func checkConfig() error {
+    if _, err := mapFacilityString(flagSyslogFacility); err != nil {
+        return err
+    }
+    if _, err := mapSeverityString(flagMinLevel); err != nil {
+        return err
+    }
+    if flagRateSummary <= 0 {
+        return fmt.Errorf("invalid ratesummary '%s', must be positive", flagRateSummary)
+    }
+    return nil
+}
+
+func snapshotFlags() map[string]string {
+    values := make(map[string]string)
+    flag.VisitAll(func(f *flag.Flag) {
+        values[f.Name] = f.Value.String()
+    })
+    return values
+}
+
+func restoreFlags(values map[string]string) {
+    flag.VisitAll(func(f *flag.Flag) {
+        if v, ok := values[f.Name]; ok {
+            f.Value.Set(v)
+        }
+    })
+}
+
+// reloadConfig is called from the main loop on SIGHUP, in between the
+// processing of two lines, so nothing queued in the scandata channel is
+// lost. If the new configuration can't be applied we keep the old one.
+func reloadConfig() {
+    old := snapshotFlags()
+
+    err := loadConfig()
+    if err == nil {
+        err = checkConfig()
+    }
+    var newRules *ruleSet
+    if err == nil {
+        newRules, err = buildRuleSet()
+    }
+    if err != nil {
+        restoreFlags(old)
+        logWriter.Err(fmt.Sprintf("%s configuration reload failed, keeping current configuration: %s", appTagVersion, err))
+        return
+    }
+
+    if flagDryRun {
+        rules = newRules
+        scanFormat.Store(flagLogformat)
+        return
+    }
+
+    // report what has been suppressed by the current rules
+    flushRepeatedMessages(true)
+    sendRateLimitSummaries()
+
+    // only destinations with changed settings are opened again
+    newWriter, err := openLogWriter(&logWriter)
+    if err != nil {
+        restoreFlags(old)
+        logWriter.Err(fmt.Sprintf("%s configuration reload failed, keeping current configuration: %s", appTagVersion, err))
+        return
+    }
+    logWriter.closeExcept(&newWriter)
+    logWriter = newWriter
+    rules = newRules
+    scanFormat.Store(flagLogformat)
+
+    logWriter.Info(appTagVersion + " configuration reloaded.")
+}
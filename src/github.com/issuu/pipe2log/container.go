@@ -0,0 +1,200 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "regexp"
+    "strings"
+)
+
+// Container detection looks for a container id in the cgroups and mounts
+// of our own process, and for the environment set up by docker, podman,
+// kubernetes and nomad. This replaces passing '-hostname +<container id>'
+// from every Dockerfile.
+
+var flagContainer string
+
+// overridden by the tests with the files in testdata/container
+var (
+    procSelfCgroup = "/proc/self/cgroup"
+    procSelfMountinfo = "/proc/self/mountinfo"
+    dockerEnvFile = "/.dockerenv"
+    podmanEnvFile = "/run/.containerenv"
+    kubernetesNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// environment variables commonly set to the image name or version
+var containerImageEnv = []string{"IMAGE", "IMAGE_NAME", "IMAGE_TAG", "IMAGE_VERSION", "DOCKER_IMAGE", "CONTAINER_IMAGE"}
+
+type containerInfo struct {
+    id string
+    runtime string
+    pod string
+    namespace string
+    tags []tag
+}
+
+var containerID_re = regexp.MustCompile(`[0-9a-f]{64}`)
+var mountContainerID_re = regexp.MustCompile(`containers/([0-9a-f]{64})/`)
+var shortContainerID_re = regexp.MustCompile(`^[0-9a-f]{12}$`)
+
+func fileExists(path string) bool {
+    _, err := os.Stat(path)
+    return err == nil
+}
+
+// containerRuntime guesses the runtime from a cgroup path
+func containerRuntime(cgroup string) string {
+    switch {
+    case strings.Contains(cgroup, "libpod"):
+        return "podman"
+    case strings.Contains(cgroup, "crio"):
+        return "cri-o"
+    case strings.Contains(cgroup, "containerd"):
+        return "containerd"
+    case strings.Contains(cgroup, "docker"):
+        return "docker"
+    }
+    return ""
+}
+
+// detectContainer returns nil if we don't seem to run in a container
+func detectContainer() *containerInfo {
+    c := &containerInfo{}
+
+    // cgroup v1, and cgroup v2 with a private cgroup namespace disabled
+    if content, err := ioutil.ReadFile(procSelfCgroup); err == nil {
+        for _, line := range strings.Split(string(content), "\n") {
+            if id := containerID_re.FindString(line); id != "" {
+                c.id = id
+                c.runtime = containerRuntime(line)
+                break
+            }
+        }
+    }
+    // cgroup v2, the container's hostname and resolv.conf are mounted
+    // from the container directory
+    if c.id == "" {
+        if content, err := ioutil.ReadFile(procSelfMountinfo); err == nil {
+            if rs := mountContainerID_re.FindStringSubmatch(string(content)); rs != nil {
+                c.id = rs[1]
+                if strings.Contains(string(content), "/docker/containers/") {
+                    c.runtime = "docker"
+                } else if strings.Contains(string(content), "overlay-containers/") {
+                    c.runtime = "podman"
+                }
+            }
+        }
+    }
+    if c.runtime == "" {
+        if fileExists(dockerEnvFile) {
+            c.runtime = "docker"
+        } else if fileExists(podmanEnvFile) {
+            c.runtime = "podman"
+        }
+    }
+    // docker uses the short container id as the default hostname
+    if c.id == "" && c.runtime != "" && shortContainerID_re.MatchString(os_hostname) {
+        c.id = os_hostname
+    }
+
+    if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+        c.pod = os.Getenv("POD_NAME")
+        if c.pod == "" {
+            // the pod name is the default hostname
+            c.pod = os_hostname
+        }
+        c.namespace = os.Getenv("POD_NAMESPACE")
+        if c.namespace == "" {
+            if content, err := ioutil.ReadFile(kubernetesNamespaceFile); err == nil {
+                c.namespace = strings.TrimSpace(string(content))
+            }
+        }
+    }
+    if job := os.Getenv("NOMAD_JOB_NAME"); job != "" {
+        c.tags = append(c.tags, tag{name: "nomad_job", value: job})
+        if alloc := os.Getenv("NOMAD_ALLOC_ID"); alloc != "" {
+            c.tags = append(c.tags, tag{name: "nomad_alloc", value: alloc})
+        }
+    }
+
+    if c.id == "" && c.runtime == "" && c.pod == "" && len(c.tags) == 0 {
+        return nil
+    }
+
+    for _, name := range containerImageEnv {
+        if value := os.Getenv(name); value != "" {
+            c.tags = append(c.tags, tag{name: strings.ToLower(name), value: value})
+        }
+    }
+    return c
+}
+
+func (c *containerInfo) shortID() string {
+    if len(c.id) > 12 {
+        return c.id[:12]
+    }
+    return c.id
+}
+
+func hasTag(tags []tag, name string) bool {
+    for _, t := range tags {
+        if t.name == name {
+            return true
+        }
+    }
+    return false
+}
+
+// addContainerInfo adds the detected container to the tags or hostname
+func addContainerInfo(r *ruleSet) error {
+    var useTags, useHostname bool
+    switch flagContainer {
+    case "":
+        return nil
+    case "tags":
+        useTags = true
+    case "hostname":
+        useHostname = true
+    case "all":
+        useTags, useHostname = true, true
+    default:
+        return fmt.Errorf("Unsupported container option '%s', tags, hostname and all are supported.", flagContainer)
+    }
+
+    c := detectContainer()
+    if c == nil {
+        return nil
+    }
+    if useTags {
+        var tags []tag
+        if c.id != "" {
+            tags = append(tags, tag{name: "container_id", value: c.shortID()})
+        }
+        if c.runtime != "" {
+            tags = append(tags, tag{name: "container_runtime", value: c.runtime})
+        }
+        if c.pod != "" {
+            tags = append(tags, tag{name: "pod", value: c.pod})
+        }
+        if c.namespace != "" {
+            tags = append(tags, tag{name: "namespace", value: c.namespace})
+        }
+        tags = append(tags, c.tags...)
+        // tags given by the user win
+        for _, t := range tags {
+            if !hasTag(r.tags, t.name) {
+                r.tags = append(r.tags, t)
+            }
+        }
+    }
+    if useHostname {
+        if c.pod != "" {
+            r.hostnamePrefix = c.pod
+        } else {
+            r.hostnamePrefix = c.shortID()
+        }
+    }
+    return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+const testContainerID = "3f4e5d6c7b8a99887766554433221100ffeeddccbbaa99887766554433221100"
+
+var containerEnv = append([]string{"KUBERNETES_SERVICE_HOST", "POD_NAME", "POD_NAMESPACE", "NOMAD_JOB_NAME", "NOMAD_ALLOC_ID"}, containerImageEnv...)
+
+// useContainerFixtures reads the container files from testdata/container,
+// a file name that isn't there stands for a file that doesn't exist, and
+// sets only the given environment, until restore is called
+func useContainerFixtures(cgroup, mountinfo, dockerEnv, hostname string, env map[string]string) (restore func()) {
+    oldFiles := []string{procSelfCgroup, procSelfMountinfo, dockerEnvFile, podmanEnvFile, kubernetesNamespaceFile, os_hostname}
+    oldEnv := map[string]string{}
+    for _, name := range containerEnv {
+        if value, ok := os.LookupEnv(name); ok {
+            oldEnv[name] = value
+        }
+        os.Unsetenv(name)
+    }
+    for name, value := range env {
+        os.Setenv(name, value)
+    }
+    fixture := func(name string) string {
+        return filepath.Join("testdata", "container", name)
+    }
+    procSelfCgroup, procSelfMountinfo, dockerEnvFile = fixture(cgroup), fixture(mountinfo), fixture(dockerEnv)
+    podmanEnvFile, kubernetesNamespaceFile = fixture("containerenv"), fixture("namespace")
+    os_hostname = hostname
+
+    return func() {
+        procSelfCgroup, procSelfMountinfo, dockerEnvFile, podmanEnvFile, kubernetesNamespaceFile, os_hostname =
+            oldFiles[0], oldFiles[1], oldFiles[2], oldFiles[3], oldFiles[4], oldFiles[5]
+        for _, name := range containerEnv {
+            os.Unsetenv(name)
+            if value, ok := oldEnv[name]; ok {
+                os.Setenv(name, value)
+            }
+        }
+    }
+}
+
+func TestDetectContainer(t *testing.T) {
+    tests := []struct {
+        name string
+        cgroup string
+        mountinfo string
+        dockerEnv string
+        hostname string
+        env map[string]string
+        id string
+        runtime string
+        pod string
+        namespace string
+    }{
+        {"cgroup v1", "cgroup-docker", "mountinfo-host", "none", "web1", nil, testContainerID, "docker", "", ""},
+        {"podman cgroup", "cgroup-podman", "mountinfo-host", "none", "web1", nil, testContainerID, "podman", "", ""},
+        {"cgroup v2 mounts", "cgroup-v2", "mountinfo-docker", "none", "web1", nil, testContainerID, "docker", "", ""},
+        {"dockerenv hostname", "cgroup-v2", "mountinfo-host", "dockerenv", "3f4e5d6c7b8a", nil, "3f4e5d6c7b8a", "docker", "", ""},
+        {"dockerenv only", "cgroup-v2", "mountinfo-host", "dockerenv", "web1", nil, "", "docker", "", ""},
+        {"kubernetes", "cgroup-docker", "mountinfo-host", "none", "web-5d8f7", map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"},
+            testContainerID, "docker", "web-5d8f7", "kube-system"},
+        {"kubernetes env", "cgroup-v2", "mountinfo-host", "none", "web1",
+            map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1", "POD_NAME": "web", "POD_NAMESPACE": "prod"}, "", "", "web", "prod"},
+    }
+    for _, test := range tests {
+        restore := useContainerFixtures(test.cgroup, test.mountinfo, test.dockerEnv, test.hostname, test.env)
+        c := detectContainer()
+        restore()
+        if c == nil {
+            t.Errorf("%s: no container detected", test.name)
+            continue
+        }
+        if c.id != test.id || c.runtime != test.runtime || c.pod != test.pod || c.namespace != test.namespace {
+            t.Errorf("%s: got %+v, expected id %q runtime %q pod %q namespace %q", test.name, c, test.id, test.runtime, test.pod, test.namespace)
+        }
+    }
+}
+
+func TestDetectNoContainer(t *testing.T) {
+    restore := useContainerFixtures("cgroup-v2", "mountinfo-host", "none", "3f4e5d6c7b8a", map[string]string{"IMAGE": "web:1.2"})
+    defer restore()
+    if c := detectContainer(); c != nil {
+        t.Errorf("got %+v, expected no container", c)
+    }
+}
+
+func TestAddContainerInfo(t *testing.T) {
+    restore := useContainerFixtures("cgroup-docker", "mountinfo-host", "none", "web1",
+        map[string]string{"NOMAD_JOB_NAME": "web", "IMAGE_TAG": "1.2"})
+    defer restore()
+    defer func(container string) { flagContainer = container }(flagContainer)
+    flagContainer = "all"
+    r := &ruleSet{tags: []tag{{name: "container_runtime", value: "mine"}}}
+    if err := addContainerInfo(r); err != nil {
+        t.Fatal(err)
+    }
+    expected := []tag{{"container_runtime", "mine"}, {"container_id", testContainerID[:12]}, {"nomad_job", "web"}, {"image_tag", "1.2"}}
+    if len(r.tags) != len(expected) {
+        t.Fatalf("got tags %v, expected %v", r.tags, expected)
+    }
+    for i, tag := range expected {
+        if r.tags[i] != tag {
+            t.Errorf("got tag %v, expected %v", r.tags[i], tag)
+        }
+    }
+    if r.hostnamePrefix != testContainerID[:12] {
+        t.Errorf("got hostname prefix %q, expected %q", r.hostnamePrefix, testContainerID[:12])
+    }
+
+    flagContainer = "everything"
+    if err := addContainerInfo(&ruleSet{}); err == nil {
+        t.Errorf("expected an error for container option '%s'", flagContainer)
+    }
+}
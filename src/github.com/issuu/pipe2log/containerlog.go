@@ -0,0 +1,144 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net"
+    "strings"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+// Container runtimes write log files with a line per line of output,
+//   docker: {"log":"listening on :8080\n","stream":"stdout","time":"2019-01-02T15:04:05.123456789Z"}
+//   cri:    2019-01-02T15:04:05.123456789Z stdout F listening on :8080
+// Long lines are split by the runtime into partial lines, a docker log
+// without a newline at the end or a cri P line, these are put together
+// again, a partial line without the rest is sent as it is after
+// partialLineTimeout. The content is parsed with -innerformat, when it has no severity
+// of its own stdout is info and stderr is err.
+
+var flagInnerFormat string
+
+// partial lines longer than this are sent as they are
+const maxPartialLineSize = 1024 * 1024
+
+// partial lines waiting longer than this for the rest are sent as they are
+const partialLineTimeout = 5 * time.Second
+
+var streamSeverity = map[string]syslog.Priority{
+    "stdout": syslog.LOG_INFO,
+    "stderr": syslog.LOG_ERR,
+}
+
+func containerLogformat(format string) bool {
+    return format == "docker" || format == "cri"
+}
+
+type dockerLogLine struct {
+    Log string `json:"log"`
+    Stream string `json:"stream"`
+    Time string `json:"time"`
+}
+
+// partialKey is a stream of an input with partial lines, the clients of
+// a socket input each have their own
+type partialKey struct {
+    source *inputSource
+    conn net.Conn
+    path string
+    stream string
+}
+
+type partialLine struct {
+    time time.Time
+    data []byte
+    // when the first part was read
+    received time.Time
+}
+
+// the partial lines waiting for the rest, only used by the main loop
+var partialLines = make(map[partialKey]*partialLine)
+
+// parseContainerLog parses a docker or cri log line, nil if it is only
+// part of a line.
+func parseContainerLog(format string, data scandata) *logMessage {
+    var stream, content, ts string
+    partial := false
+    if format == "docker" {
+        var line dockerLogLine
+        if err := json.Unmarshal(data.data, &line); err != nil {
+            lm, _ := parseLogLine("", data.data)
+            return lm
+        }
+        stream, ts = line.Stream, line.Time
+        content = strings.TrimSuffix(line.Log, "\n")
+        partial = content == line.Log
+    } else {
+        // TIME STREAM TAGS CONTENT, the tags are P or F with optional others
+        parts := strings.SplitN(string(data.data), " ", 4)
+        if len(parts) < 3 {
+            lm, _ := parseLogLine("", data.data)
+            return lm
+        }
+        ts, stream = parts[0], parts[1]
+        partial = strings.HasPrefix(parts[2], "P")
+        if len(parts) == 4 {
+            content = parts[3]
+        }
+    }
+    t, err := time.Parse(time.RFC3339Nano, ts)
+    if err != nil {
+        t = time.Now()
+    }
+
+    key := partialKey{source: data.source, conn: data.conn, stream: stream}
+    if data.pos != nil {
+        key.path = data.pos.Path
+    }
+    if p, ok := partialLines[key]; ok {
+        p.data = append(p.data, content...)
+        if partial && len(p.data) < maxPartialLineSize {
+            return nil
+        }
+        delete(partialLines, key)
+        t = p.time
+        content = string(p.data)
+    } else if partial {
+        partialLines[key] = &partialLine{time: t, data: []byte(content), received: time.Now()}
+        return nil
+    }
+    return containerLogMessage(stream, t, content)
+}
+
+// containerLogMessage parses the content of a line from stream, logged
+// at t
+func containerLogMessage(stream string, t time.Time, content string) *logMessage {
+    lm, found := parseLogLine(flagInnerFormat, bytes.TrimRight([]byte(content), "\r"))
+    if !found {
+        if severity, ok := streamSeverity[stream]; ok {
+            lm.severity = severity
+        }
+    }
+    if lm.time.IsZero() {
+        lm.time = t
+    }
+    return lm
+}
+
+// flushPartialLines sends the partial lines waiting too long for the rest,
+// or all of them with force
+func flushPartialLines(force bool) {
+    for key, p := range partialLines {
+        if !force && time.Since(p.received) < partialLineTimeout {
+            continue
+        }
+        delete(partialLines, key)
+        lm := containerLogMessage(key.stream, p.time, string(p.data))
+        if key.source != nil && key.source.appname != "" {
+            lm.appname = key.source.appname
+        }
+        processMessage(lm)
+    }
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "net"
+    "testing"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+// testSink collects the messages written to it
+type testSink struct {
+    messages []*logMessage
+}
+
+func (s *testSink) write(m *logMessage) error {
+    s.messages = append(s.messages, m)
+    return nil
+}
+
+func (s *testSink) close() error {
+    return nil
+}
+
+// useTestPipeline sends the processed messages to the returned sink, with
+// the rules of the default flags, until restore is called
+func useTestPipeline(t *testing.T) (sink *testSink, restore func()) {
+    oldRules, oldWriter, oldDefault := rules, logWriter, flagDefaultSeverity
+    flagDefaultSeverity = "info"
+    r := &ruleSet{}
+    var err error
+    if r.severities, err = newSeverityScanner(); err != nil {
+        t.Fatal(err)
+    }
+    rules = r
+    sink = &testSink{}
+    logWriter = logWrapper{destinations: []*logDestination{{minSeverity: syslog.LOG_DEBUG, sink: sink}}}
+    return sink, func() {
+        rules, logWriter, flagDefaultSeverity = oldRules, oldWriter, oldDefault
+        partialLines = make(map[partialKey]*partialLine)
+    }
+}
+
+func TestParseContainerLog(t *testing.T) {
+    _, restore := useTestPipeline(t)
+    defer restore()
+    tests := []struct {
+        format string
+        lines []string
+        severity syslog.Priority
+        msg string
+    }{
+        {"docker", []string{`{"log":"listening on :8080\n","stream":"stdout","time":"2019-01-02T15:04:05.123456789Z"}`}, syslog.LOG_INFO, "listening on :8080"},
+        {"docker", []string{`{"log":"failed\n","stream":"stderr","time":"2019-01-02T15:04:05.123456789Z"}`}, syslog.LOG_ERR, "failed"},
+        {"docker", []string{`{"log":"WARN disk ","stream":"stdout","time":"2019-01-02T15:04:05.123456789Z"}`,
+            `{"log":"almost full\n","stream":"stdout","time":"2019-01-02T15:04:06Z"}`}, syslog.LOG_WARNING, "disk almost full"},
+        {"cri", []string{"2019-01-02T15:04:05.123456789Z stdout F listening on :8080"}, syslog.LOG_INFO, "listening on :8080"},
+        {"cri", []string{"2019-01-02T15:04:05.123456789Z stderr P fail", "2019-01-02T15:04:06Z stderr F ed"}, syslog.LOG_ERR, "failed"},
+        {"cri", []string{"garbage"}, syslog.LOG_INFO, "garbage"},
+    }
+    for _, test := range tests {
+        var lm *logMessage
+        for i, line := range test.lines {
+            lm = parseContainerLog(test.format, scandata{data: []byte(line)})
+            if i < len(test.lines)-1 && lm != nil {
+                t.Errorf("%s: got %q for a partial line", test.lines, lm.msg)
+            }
+        }
+        if lm == nil {
+            t.Errorf("%s: no message", test.lines)
+            continue
+        }
+        if lm.severity != test.severity || lm.msg != test.msg {
+            t.Errorf("%s: got %d %q, expected %d %q", test.lines, lm.severity, lm.msg, test.severity, test.msg)
+        }
+    }
+}
+
+func TestPartialLinesPerConnection(t *testing.T) {
+    _, restore := useTestPipeline(t)
+    defer restore()
+    source := &inputSource{format: "cri"}
+    one, other := &net.UnixConn{}, &net.UnixConn{}
+    parts := []struct {
+        conn net.Conn
+        line string
+        want string
+    }{
+        {one, "2019-01-02T15:04:05Z stdout P one ", ""},
+        {other, "2019-01-02T15:04:05Z stdout P other ", ""},
+        {one, "2019-01-02T15:04:05Z stdout F line", "one line"},
+        {other, "2019-01-02T15:04:05Z stdout F line", "other line"},
+    }
+    for _, part := range parts {
+        lm := parseContainerLog("cri", scandata{data: []byte(part.line), source: source, conn: part.conn})
+        switch {
+        case part.want == "" && lm != nil:
+            t.Errorf("%s: got %q for a partial line", part.line, lm.msg)
+        case part.want != "" && (lm == nil || lm.msg != part.want):
+            t.Errorf("%s: got %v, expected %q", part.line, lm, part.want)
+        }
+    }
+}
+
+func TestFlushPartialLines(t *testing.T) {
+    sink, restore := useTestPipeline(t)
+    defer restore()
+    source := &inputSource{format: "docker", appname: "web"}
+    stale := `{"log":"ERROR never","stream":"stdout","time":"2019-01-02T15:04:05Z"}`
+    fresh := `{"log":"still coming","stream":"stderr","time":"2019-01-02T15:04:05Z"}`
+    parseContainerLog("docker", scandata{data: []byte(stale), source: source})
+    for _, p := range partialLines {
+        p.received = time.Now().Add(-partialLineTimeout)
+    }
+    parseContainerLog("docker", scandata{data: []byte(fresh), source: source})
+
+    flushPartialLines(false)
+    if len(sink.messages) != 1 || len(partialLines) != 1 {
+        t.Fatalf("sent %d and kept %d partial lines, expected the stale one sent", len(sink.messages), len(partialLines))
+    }
+    m := sink.messages[0]
+    if m.severity != syslog.LOG_ERR || m.msg != "never" || m.appname != "web" || !m.time.Equal(time.Date(2019, 1, 2, 15, 4, 5, 0, time.UTC)) {
+        t.Errorf("got %+v", m)
+    }
+
+    flushPartialLines(true)
+    if len(sink.messages) != 2 || len(partialLines) != 0 || sink.messages[1].msg != "still coming" {
+        t.Errorf("got %d messages, %d partial lines left", len(sink.messages), len(partialLines))
+    }
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "time"
+)
+
+// Consecutive identical messages are collapsed into the first message and
+// a "last message repeated N times" message, like the classic syslogd.
+// The repeat count is sent when a different message arrives, or at the
+// latest -dedup after the first repeat.
+
+var flagDedup time.Duration
+var flagDedupFuzzy bool
+
+type dedupFilter struct {
+    window time.Duration
+    fuzzy bool
+    last *logMessage
+    lastKey string
+    // number of repeats suppressed since first, the latest at lastSeen
+    count int
+    first time.Time
+    lastSeen time.Time
+}
+
+// a leading timestamp, and numbers, ignored in fuzzy mode
+var leadingTimestamp_re = regexp.MustCompile(`^[ \[]*[0-9][0-9-/:.,TZ+ ]*[\]]?[ ]*`)
+var number_re = regexp.MustCompile(`[0-9]+`)
+
+func newDedupFilter() *dedupFilter {
+    if flagDedup <= 0 {
+        return nil
+    }
+    return &dedupFilter{window: flagDedup, fuzzy: flagDedupFuzzy}
+}
+
+func (d *dedupFilter) key(m *logMessage) string {
+    text := m.text()
+    if d.fuzzy {
+        text = leadingTimestamp_re.ReplaceAllString(text, "")
+        text = number_re.ReplaceAllString(text, "#")
+    }
+    // the same line from another host or process isn't a repeat
+    return fmt.Sprintf("%d|%s|%s|%s|%s", m.severity, m.host(), m.app(), m.procID(), text)
+}
+
+// check returns true if m repeats the last message, and the repeat
+// count of the previous message if m is different.
+func (d *dedupFilter) check(m *logMessage) (*logMessage, bool) {
+    key := d.key(m)
+    if d.last != nil && key == d.lastKey {
+        if d.count == 0 {
+            d.first = time.Now()
+        }
+        d.count++
+        d.lastSeen = m.time
+        if d.lastSeen.IsZero() {
+            d.lastSeen = time.Now()
+        }
+        return nil, true
+    }
+    repeated := d.repeated()
+    d.last = m
+    d.lastKey = key
+    return repeated, false
+}
+
+// repeated returns the repeat count message, from the sender of the
+// repeated message, and resets the count
+func (d *dedupFilter) repeated() *logMessage {
+    if d.count == 0 {
+        return nil
+    }
+    var msg string
+    if d.count == 1 {
+        msg = "last message repeated 1 time"
+    } else {
+        msg = fmt.Sprintf("last message repeated %d times", d.count)
+    }
+    d.count = 0
+    return &logMessage{severity: d.last.severity, msg: msg, time: d.lastSeen, tags: d.last.tags,
+        hostname: d.last.hostname, appname: d.last.appname, procid: d.last.procid, facility: d.last.facility}
+}
+
+// expired returns the repeat count message if the window has passed
+func (d *dedupFilter) expired(now time.Time) *logMessage {
+    if d.count > 0 && now.Sub(d.first) >= d.window {
+        return d.repeated()
+    }
+    return nil
+}
+
+// flushRepeatedMessages sends the repeat count when the window has
+// passed, or right away if force is set.
+func flushRepeatedMessages(force bool) {
+    if rules.dedup == nil {
+        return
+    }
+    var m *logMessage
+    if force {
+        m = rules.dedup.repeated()
+    } else {
+        m = rules.dedup.expired(time.Now())
+    }
+    if m != nil {
+        sendMessage(m)
+    }
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestDedupFilter(t *testing.T) {
+    tests := []struct {
+        fuzzy bool
+        msgs []string
+        // the repeat counts sent, and the messages not collapsed
+        expected []string
+    }{
+        {false, []string{"one", "one", "one", "two"}, []string{"one", "last message repeated 2 times", "two"}},
+        {false, []string{"one", "two", "one"}, []string{"one", "two", "one"}},
+        {false, []string{"one", "one", "two", "two"}, []string{"one", "last message repeated 1 time", "two"}},
+        {false, []string{"took 5ms", "took 6ms"}, []string{"took 5ms", "took 6ms"}},
+        {true, []string{"took 5ms", "took 16ms", "2019-01-02 15:04:05 took 7ms", "done"},
+            []string{"took 5ms", "last message repeated 2 times", "done"}},
+    }
+    for _, test := range tests {
+        d := &dedupFilter{window: time.Minute, fuzzy: test.fuzzy}
+        var sent []string
+        for _, msg := range test.msgs {
+            repeated, duplicate := d.check(&logMessage{severity: syslog.LOG_INFO, msg: msg})
+            if repeated != nil {
+                sent = append(sent, repeated.msg)
+            }
+            if !duplicate {
+                sent = append(sent, msg)
+            }
+        }
+        if len(sent) != len(test.expected) {
+            t.Errorf("%v: got %q, expected %q", test.msgs, sent, test.expected)
+            continue
+        }
+        for i := range sent {
+            if sent[i] != test.expected[i] {
+                t.Errorf("%v: got %q, expected %q", test.msgs, sent, test.expected)
+                break
+            }
+        }
+    }
+}
+
+func TestDedupFilterSenders(t *testing.T) {
+    d := &dedupFilter{window: time.Minute}
+    msgs := []*logMessage{
+        {severity: syslog.LOG_ERR, msg: "failed", hostname: "web1", appname: "app"},
+        {severity: syslog.LOG_ERR, msg: "failed", hostname: "web2", appname: "app"},
+        {severity: syslog.LOG_ERR, msg: "failed", hostname: "web2", appname: "worker"},
+        {severity: syslog.LOG_ERR, msg: "failed", hostname: "web2", appname: "worker", procid: "42"},
+        {severity: syslog.LOG_WARNING, msg: "failed", hostname: "web2", appname: "worker", procid: "42"},
+    }
+    for _, m := range msgs {
+        if _, duplicate := d.check(m); duplicate {
+            t.Errorf("%+v: collapsed with another sender or severity", m)
+        }
+    }
+}
+
+func TestDedupFilterRepeatedMessage(t *testing.T) {
+    d := &dedupFilter{window: time.Minute}
+    tags := []tag{{"env", "prod"}}
+    later := testTime.Add(time.Second)
+    d.check(&logMessage{severity: syslog.LOG_ERR, msg: "failed", time: testTime, tags: tags, hostname: "web1", appname: "app", procid: "42", facility: "auth"})
+    d.check(&logMessage{severity: syslog.LOG_ERR, msg: "failed", time: later, hostname: "web1", appname: "app", procid: "42", facility: "auth"})
+    m := d.repeated()
+    if m == nil {
+        t.Fatal("no repeat count")
+    }
+    if m.msg != "last message repeated 1 time" || m.severity != syslog.LOG_ERR || !m.time.Equal(later) || len(m.tags) != 1 ||
+        m.hostname != "web1" || m.appname != "app" || m.procid != "42" || m.facility != "auth" {
+        t.Errorf("got %+v", m)
+    }
+    if d.repeated() != nil {
+        t.Errorf("the count wasn't reset")
+    }
+}
+
+func TestDedupFilterExpired(t *testing.T) {
+    d := &dedupFilter{window: time.Minute}
+    if d.expired(time.Now()) != nil {
+        t.Errorf("expired without repeats")
+    }
+    d.check(&logMessage{msg: "one"})
+    d.check(&logMessage{msg: "one"})
+    now := time.Now()
+    if d.expired(now.Add(30 * time.Second)) != nil {
+        t.Errorf("expired within the window")
+    }
+    if m := d.expired(now.Add(time.Minute)); m == nil || m.msg != "last message repeated 1 time" {
+        t.Errorf("got %v after the window", m)
+    }
+    // the message is still the last one, a new repeat starts a new count
+    if _, duplicate := d.check(&logMessage{msg: "one"}); !duplicate {
+        t.Errorf("not collapsed after the window")
+    }
+    if m := d.expired(time.Now().Add(time.Minute)); m == nil || m.msg != "last message repeated 1 time" {
+        t.Errorf("got %v for the second window", m)
+    }
+}
+
+func TestNewDedupFilter(t *testing.T) {
+    defer func(dedup time.Duration, fuzzy bool) { flagDedup, flagDedupFuzzy = dedup, fuzzy }(flagDedup, flagDedupFuzzy)
+    flagDedup, flagDedupFuzzy = 0, true
+    if d := newDedupFilter(); d != nil {
+        t.Errorf("got a filter without -dedup")
+    }
+    flagDedup = 30 * time.Second
+    if d := newDedupFilter(); d == nil || d.window != flagDedup || !d.fuzzy {
+        t.Errorf("got %+v", d)
+    }
+}
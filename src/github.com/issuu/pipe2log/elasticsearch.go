@@ -0,0 +1,165 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "log"
+    url "net/url"
+    "regexp"
+    "strings"
+)
+
+// elasticsearchSink writes batches of documents with the bulk api of
+// Elasticsearch or OpenSearch, i.e.
+//   elasticsearch+https://es:9200?index=app-{2006.01.02}
+// The index is a pattern, the parts in braces are formatted with the
+// message time (UTC) as a go time layout. Documents that fail with 429 or
+// a server error are retried, other failures are logged and dropped.
+type elasticsearchSink struct {
+    index string
+    op string
+    fields string
+    poster *httpPoster
+    batcher *batcher
+}
+
+const elasticsearchScheme = "elasticsearch+"
+
+var indexPattern_re = regexp.MustCompile(`\{[^}]*\}`)
+
+// the options of the elasticsearch destination, other options are sent
+// to the server, i.e. pipeline
+var elasticsearchOptionNames = []string{"index", "op", "fields"}
+
+func openElasticsearchSink(spec, uri string, options url.Values) (logSink, error) {
+    s := &elasticsearchSink{index: options.Get("index"), op: options.Get("op"), fields: options.Get("fields")}
+    uri = strings.TrimPrefix(uri, elasticsearchScheme)
+    if !strings.HasPrefix(uri, "http:") && !strings.HasPrefix(uri, "https:") {
+        return nil, fmt.Errorf("invalid elasticsearch destination '%s', use elasticsearch+http(s)://host:9200", spec)
+    }
+    if s.index == "" {
+        s.index = "pipe2log-{2006.01.02}"
+    }
+    switch s.op {
+    case "":
+        s.op = "index"
+    case "index", "create":
+    default:
+        return nil, fmt.Errorf("Unsupported op '%s' for destination '%s', index and create are supported.", s.op, spec)
+    }
+    if s.fields == "" {
+        s.fields = "fields"
+    }
+    batchOptions, err := parseBatchOptions(spec, options)
+    if err != nil {
+        return nil, err
+    }
+    s.poster, err = newHTTPPoster(spec, uri, options, elasticsearchOptionNames...)
+    if err != nil {
+        return nil, err
+    }
+    s.batcher = newBatcher(uri, batchOptions, s.send)
+    return s, nil
+}
+
+func (s *elasticsearchSink) indexName(m *logMessage) string {
+    t := m.time.UTC()
+    return indexPattern_re.ReplaceAllStringFunc(s.index, func(layout string) string {
+        return t.Format(layout[1 : len(layout)-1])
+    })
+}
+
+// document returns the json document of m. The parsed fields are kept in
+// an object named by the fields option, or with fields=root merged into
+// the document, without replacing the standard fields.
+func (s *elasticsearchSink) document(m *logMessage) []byte {
+    doc := make(map[string]interface{})
+    if s.fields == "root" {
+        for name, value := range m.fields {
+            doc[name] = value
+        }
+    } else if m.fields != nil {
+        doc[s.fields] = m.fields
+    }
+    doc["@timestamp"] = m.time.Format(RFC3339Micro)
+    doc["severity"] = severityNames[m.severity&0x07]
+    doc["facility"] = m.facilityName()
+    doc["hostname"] = m.host()
+    doc["appname"] = m.app()
+    doc["message"] = m.msg
+    if tags := tagMap(m.tags); tags != nil {
+        doc["tags"] = tags
+    }
+    _byteArray, err := json.Marshal(doc)
+    if err != nil {
+        delete(doc, s.fields)
+        _byteArray, _ = json.Marshal(doc)
+    }
+    return _byteArray
+}
+
+type bulkResponse struct {
+    Errors bool `json:"errors"`
+    Items []map[string]struct {
+        Status int `json:"status"`
+        Error json.RawMessage `json:"error"`
+    } `json:"items"`
+}
+
+func (s *elasticsearchSink) send(batch []*logMessage) error {
+    var body bytes.Buffer
+    for _, m := range batch {
+        action, _ := json.Marshal(map[string]map[string]string{s.op: {"_index": s.indexName(m)}})
+        body.Write(action)
+        body.WriteByte('\n')
+        body.Write(s.document(m))
+        body.WriteByte('\n')
+    }
+    response, err := s.poster.post("/_bulk", body.Bytes(), "application/x-ndjson")
+    if err != nil {
+        return err
+    }
+    var result bulkResponse
+    if err = json.Unmarshal(response, &result); err != nil {
+        return permanentError{fmt.Errorf("invalid bulk response: %s", err)}
+    }
+    if !result.Errors {
+        return nil
+    }
+    var retry []*logMessage
+    var failed int
+    var lastError string
+    for i, item := range result.Items {
+        if i >= len(batch) {
+            break
+        }
+        for _, status := range item {
+            switch {
+            case status.Status == 429 || status.Status >= 500:
+                retry = append(retry, batch[i])
+                lastError = string(status.Error)
+            case status.Status >= 300:
+                failed++
+                lastError = string(status.Error)
+            }
+        }
+    }
+    if failed > 0 {
+        log.Printf("%s %s dropped %d rejected documents: %s\n", appTagVersion, s.poster.url.Host, failed, lastError)
+    }
+    if len(retry) > 0 {
+        return retryItems{messages: retry, err: fmt.Errorf("%d of %d documents failed: %s", len(retry), len(batch), lastError)}
+    }
+    return nil
+}
+
+func (s *elasticsearchSink) write(m *logMessage) error {
+    s.batcher.add(m)
+    return nil
+}
+
+func (s *elasticsearchSink) close() error {
+    s.batcher.close()
+    return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+    "testing"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestElasticsearchIndexName(t *testing.T) {
+    tests := []struct {
+        index string
+        want string
+    }{
+        {"pipe2log-{2006.01.02}", "pipe2log-2019.01.02"},
+        {"app", "app"},
+        {"app-{2006-01}", "app-2019-01"},
+        {"{2006}-app-{01.02}", "2019-app-01.02"},
+    }
+    for _, test := range tests {
+        s := &elasticsearchSink{index: test.index}
+        if got := s.indexName(&logMessage{time: testTime}); got != test.want {
+            t.Errorf("%s: got %s, expected %s", test.index, got, test.want)
+        }
+    }
+}
+
+func TestElasticsearchBulkBody(t *testing.T) {
+    m := &logMessage{severity: syslog.LOG_WARNING, msg: "disk almost full", time: testTime, hostname: "web1", appname: "app", facility: "user",
+        fields: map[string]interface{}{"disk": "/var", "message": "replaced"}, tags: []tag{{"env", "prod"}}}
+    tests := []struct {
+        query string
+        want string
+    }{
+        {
+            "",
+            `{"index":{"_index":"pipe2log-2019.01.02"}}
+{"@timestamp":"2019-01-02T15:04:05.123456Z","appname":"app","facility":"user","fields":{"disk":"/var","message":"replaced"},"hostname":"web1","message":"disk almost full","severity":"warning","tags":{"env":"prod"}}
+`,
+        },
+        {
+            // the standard fields win over the parsed fields
+            "?index=logs-{2006.01}&op=create&fields=root",
+            `{"create":{"_index":"logs-2019.01"}}
+{"@timestamp":"2019-01-02T15:04:05.123456Z","appname":"app","disk":"/var","facility":"user","hostname":"web1","message":"disk almost full","severity":"warning","tags":{"env":"prod"}}
+`,
+        },
+        {
+            "?fields=data",
+            `{"index":{"_index":"pipe2log-2019.01.02"}}
+{"@timestamp":"2019-01-02T15:04:05.123456Z","appname":"app","data":{"disk":"/var","message":"replaced"},"facility":"user","hostname":"web1","message":"disk almost full","severity":"warning","tags":{"env":"prod"}}
+`,
+        },
+    }
+    for _, test := range tests {
+        server := newTestServer(func(r *http.Request, body []byte) (int, string) {
+            return http.StatusOK, `{"errors":false,"items":[]}`
+        })
+        sink := openTestSink(t, openElasticsearchSink, "elasticsearch+"+server.URL+test.query)
+        sink.write(m)
+        sink.close()
+        server.Close()
+        if len(server.requests) != 1 {
+            t.Errorf("%s: got %d requests, expected 1", test.query, len(server.requests))
+            continue
+        }
+        if path := server.requests[0].URL.Path; path != "/_bulk" {
+            t.Errorf("%s: posted to %s", test.query, path)
+        }
+        if got := string(server.bodies[0]); got != test.want {
+            t.Errorf("%s: got %s, expected %s", test.query, got, test.want)
+        }
+    }
+}
+
+func TestElasticsearchRetriesFailedItems(t *testing.T) {
+    server := newTestServer(func(r *http.Request, body []byte) (int, string) {
+        if strings.Count(string(body), "\n") == 6 {
+            return http.StatusOK, `{"errors":true,"items":[
+                {"index":{"status":201}},
+                {"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},
+                {"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`
+        }
+        return http.StatusOK, `{"errors":false,"items":[{"index":{"status":201}}]}`
+    })
+    defer server.Close()
+    sink := openTestSink(t, openElasticsearchSink, "elasticsearch+"+server.URL+"?retries=1")
+    for _, msg := range []string{"created", "rejected for now", "invalid"} {
+        sink.write(&logMessage{severity: syslog.LOG_INFO, msg: msg, time: testTime, hostname: "web1", appname: "app"})
+    }
+    sink.close()
+    if len(server.bodies) != 2 {
+        t.Fatalf("got %d requests, expected 2", len(server.bodies))
+    }
+    retried := string(server.bodies[1])
+    if strings.Count(retried, "\n") != 2 || !strings.Contains(retried, `"message":"rejected for now"`) {
+        t.Errorf("retried %s, expected only the rejected document", retried)
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strings"
+)
+
+// Tags are name/value pairs added to every message, from -tags or read from
+// the environment with -tagenv, i.e. the kubernetes downward api POD_NAME.
+// In rfc5424 messages they are sent as structured data:
+//   [pipe2log@32473 env="prod" team="publishing"]
+
+// 32473 is the private enterprise number reserved for documentation (RFC 5612)
+const defaultSDID = "pipe2log@32473"
+
+var flagTags string
+var flagTagEnv string
+var flagSDID string
+
+type tag struct {
+    name string
+    value string
+}
+
+func parseTags(tags, tagenv string) ([]tag, error) {
+    var result []tag
+    for _, item := range splitList(tags) {
+        idx := strings.Index(item, "=")
+        if idx <= 0 {
+            return nil, fmt.Errorf("invalid tag '%s', use name=value", item)
+        }
+        result = append(result, tag{name: item[:idx], value: item[idx+1:]})
+    }
+    for _, item := range splitList(tagenv) {
+        name, variable := item, item
+        if idx := strings.Index(item, "="); idx > 0 {
+            name, variable = item[:idx], item[idx+1:]
+        }
+        if value, ok := os.LookupEnv(variable); ok {
+            result = append(result, tag{name: name, value: value})
+        }
+    }
+    return result, nil
+}
+
+// sdName makes a valid rfc5424 SD-NAME, printable ascii except '= ]"'
+func sdName(name string) string {
+    b := []byte(name)
+    for i, c := range b {
+        if c <= 32 || c >= 127 || c == '=' || c == ']' || c == '"' {
+            b[i] = '_'
+        }
+    }
+    if len(b) > 32 {
+        b = b[:32]
+    }
+    return string(b)
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// structuredData formats the tags as a rfc5424 SD-ELEMENT
+func structuredData(tags []tag) string {
+    if len(tags) == 0 {
+        return ""
+    }
+    sd := "[" + sdName(flagSDID)
+    for _, t := range tags {
+        sd += " " + sdName(t.name) + `="` + sdValueEscaper.Replace(t.value) + `"`
+    }
+    return sd + "]"
+}
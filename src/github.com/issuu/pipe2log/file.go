@@ -0,0 +1,302 @@
+package main
+
+import (
+    "compress/gzip"
+    "fmt"
+    "io"
+    "log"
+    url "net/url"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+// fileSink appends messages to a file, i.e. file:///var/log/app.log
+//
+// The file is rotated when it grows larger than the maxsize option, or
+// every interval (aligned to UTC). Rotated files are renamed with a
+// timestamp suffix, app.log.20170119-235959.000, optionally gzipped, and only
+// the newest backups are kept. On SIGUSR1 the file is reopened, for use
+// with an external logrotate.
+type fileSink struct {
+    path string
+    format string
+    facility syslog.Priority
+    maxSize int64
+    interval time.Duration
+    backups int
+    compress bool
+
+    file *os.File
+    size int64
+    // when the current file has to be rotated by the interval
+    rotateAt time.Time
+    // after a failed rotation the next one is tried after a while, the
+    // failure is logged once
+    retryRotateAt time.Time
+    rotateFailed bool
+    // compressing and removing old backups happens in the background,
+    // one rotated file at a time
+    housekeeping sync.WaitGroup
+    housekeepingMutex sync.Mutex
+}
+
+const backupTimeFormat = "20060102-150405.000"
+
+// the suffix of our backups, other files like app.log.1 aren't touched
+var backupSuffix_re = regexp.MustCompile(`^\.[0-9]{8}-[0-9]{6}\.[0-9]{3}(\.gz)?$`)
+
+// how long to keep writing to the current file after a failed rotation
+const rotateRetryInterval = time.Minute
+
+// parseSize parses sizes like 512K, 100M or 1G
+func parseSize(size string) (int64, error) {
+    multiplier := int64(1)
+    switch {
+    case strings.HasSuffix(size, "K"):
+        multiplier = 1024
+    case strings.HasSuffix(size, "M"):
+        multiplier = 1024 * 1024
+    case strings.HasSuffix(size, "G"):
+        multiplier = 1024 * 1024 * 1024
+    }
+    if multiplier > 1 {
+        size = size[:len(size)-1]
+    }
+    n, err := strconv.ParseInt(size, 10, 64)
+    if err != nil || n < 0 {
+        return 0, fmt.Errorf("invalid size '%s'", size)
+    }
+    return n * multiplier, nil
+}
+
+func openFileSink(uri string, options url.Values) (logSink, error) {
+    u, err := url.Parse(uri)
+    if err != nil {
+        return nil, err
+    }
+    if u.Path == "" || u.Host != "" {
+        return nil, fmt.Errorf("invalid file destination '%s', use file:///path/to/file", uri)
+    }
+    s := &fileSink{path: u.Path, format: options.Get("format")}
+    switch s.format {
+    case "":
+        s.format = "json"
+    case "json", "text", "rfc3164", "rfc5424":
+    default:
+        return nil, fmt.Errorf("Unsupported format '%s' for destination '%s', json, text, rfc3164 and rfc5424 are supported.", s.format, uri)
+    }
+    s.facility, err = mapFacilityString(flagSyslogFacility)
+    if err != nil {
+        return nil, err
+    }
+    if v := options.Get("maxsize"); v != "" {
+        if s.maxSize, err = parseSize(v); err != nil {
+            return nil, fmt.Errorf("invalid maxsize for destination '%s': %s", uri, err)
+        }
+    }
+    if v := options.Get("interval"); v != "" {
+        if s.interval, err = time.ParseDuration(v); err != nil || s.interval <= 0 {
+            return nil, fmt.Errorf("invalid interval '%s' for destination '%s'", v, uri)
+        }
+    }
+    if v := options.Get("backups"); v != "" {
+        if s.backups, err = strconv.Atoi(v); err != nil || s.backups < 0 {
+            return nil, fmt.Errorf("invalid backups '%s' for destination '%s'", v, uri)
+        }
+    }
+    if v := options.Get("compress"); v != "" {
+        if s.compress, err = strconv.ParseBool(v); err != nil {
+            return nil, fmt.Errorf("invalid compress '%s' for destination '%s'", v, uri)
+        }
+    }
+    if err = s.open(); err != nil {
+        return nil, err
+    }
+    return s, nil
+}
+
+func (s *fileSink) open() error {
+    f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+    if err != nil {
+        return err
+    }
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return err
+    }
+    s.file = f
+    s.size = info.Size()
+    if s.interval > 0 {
+        s.rotateAt = time.Now().Truncate(s.interval).Add(s.interval)
+    }
+    return nil
+}
+
+// reopen the file after it has been moved away by logrotate, the old
+// file is kept when the new one can't be opened
+func (s *fileSink) reopen() error {
+    old := s.file
+    if err := s.open(); err != nil {
+        return err
+    }
+    old.Close()
+    return nil
+}
+
+func (s *fileSink) encode(m *logMessage) []byte {
+    switch s.format {
+    case "text":
+        return []byte(m.time.Format(RFC3339Micro) + " " + consoleSeverity[m.severity&0x07] + " " + m.text() + "\n")
+    case "rfc3164":
+        return []byte(formatRFC3164(m.severity|s.facility, m, m.text(), false) + "\n")
+    case "rfc5424":
+        return []byte(formatRFC5424(m.severity|s.facility, m, m.text()) + "\n")
+    }
+    return append(formatJSON(m), '\n')
+}
+
+func (s *fileSink) write(m *logMessage) error {
+    line := s.encode(m)
+    if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize ||
+        s.interval > 0 && !time.Now().Before(s.rotateAt) {
+        s.tryRotate()
+    }
+    n, err := s.file.Write(line)
+    s.size += int64(n)
+    return err
+}
+
+// tryRotate rotates the file, unless a rotation failed a short while ago
+func (s *fileSink) tryRotate() {
+    now := time.Now()
+    if now.Before(s.retryRotateAt) {
+        return
+    }
+    if err := s.rotate(); err != nil {
+        if !s.rotateFailed {
+            log.Printf("%s can't rotate %s: %s\n", appTagVersion, s.path, err)
+        }
+        s.rotateFailed = true
+        s.retryRotateAt = now.Add(rotateRetryInterval)
+        return
+    }
+    s.rotateFailed = false
+    s.retryRotateAt = time.Time{}
+}
+
+// rotate renames the file to a backup and opens a new one, on an error
+// the sink keeps writing to the file it has
+func (s *fileSink) rotate() error {
+    // backups have to sort in the order they were rotated
+    t := time.Now()
+    backup := s.path + "." + t.Format(backupTimeFormat)
+    for fileExists(backup) || fileExists(backup+".gz") {
+        t = t.Add(time.Millisecond)
+        backup = s.path + "." + t.Format(backupTimeFormat)
+    }
+    if err := os.Rename(s.path, backup); err != nil {
+        return err
+    }
+    old := s.file
+    if err := s.open(); err != nil {
+        os.Rename(backup, s.path)
+        return err
+    }
+    old.Close()
+    s.housekeeping.Add(1)
+    go func() {
+        defer s.housekeeping.Done()
+        s.housekeepingMutex.Lock()
+        defer s.housekeepingMutex.Unlock()
+        // already removed as one of the oldest backups
+        if !fileExists(backup) {
+            return
+        }
+        if s.compress {
+            if err := gzipFile(backup); err != nil {
+                log.Printf("%s can't compress %s: %s\n", appTagVersion, backup, err)
+            }
+        }
+        s.removeOldBackups()
+    }()
+    return nil
+}
+
+func gzipFile(path string) error {
+    in, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+    if err != nil {
+        return err
+    }
+    z := gzip.NewWriter(out)
+    if _, err = io.Copy(z, in); err == nil {
+        err = z.Close()
+    }
+    if e := out.Close(); err == nil {
+        err = e
+    }
+    if err != nil {
+        os.Remove(path + ".gz")
+        return err
+    }
+    return os.Remove(path)
+}
+
+// removeOldBackups keeps the newest backups, the timestamp suffix sorts
+// the oldest first.
+func (s *fileSink) removeOldBackups() {
+    if s.backups <= 0 {
+        return
+    }
+    all, err := filepath.Glob(s.path + ".*")
+    if err != nil {
+        return
+    }
+    var matches []string
+    for _, path := range all {
+        if backupSuffix_re.MatchString(path[len(s.path):]) {
+            matches = append(matches, path)
+        }
+    }
+    if len(matches) <= s.backups {
+        return
+    }
+    sort.Strings(matches)
+    for _, old := range matches[:len(matches)-s.backups] {
+        os.Remove(old)
+    }
+}
+
+func (s *fileSink) close() error {
+    s.housekeeping.Wait()
+    return s.file.Close()
+}
+
+// the destinations that can reopen their files
+type reopener interface {
+    reopen() error
+}
+
+// reopenFiles is called on SIGUSR1
+func reopenFiles() {
+    for _, d := range logWriter.destinations {
+        if r, ok := d.sink.(reopener); ok {
+            if err := r.reopen(); err != nil {
+                log.Printf("%s can't reopen file: %s\n", appTagVersion, err)
+            }
+        }
+    }
+}
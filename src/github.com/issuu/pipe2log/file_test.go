@@ -0,0 +1,153 @@
+package main
+
+import (
+    "compress/gzip"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func openTestFileSink(t *testing.T, options string) (*fileSink, string) {
+    dir, err := ioutil.TempDir("", "pipe2log")
+    if err != nil {
+        t.Fatal(err)
+    }
+    path := filepath.Join(dir, "app.log")
+    spec := "file://" + path + "?format=text&" + options
+    uri, values, _ := parseDestination(spec)
+    sink, err := openFileSink(uri, values)
+    if err != nil {
+        os.RemoveAll(dir)
+        t.Fatal(err)
+    }
+    return sink.(*fileSink), dir
+}
+
+// backupFiles returns the names of the files next to app.log
+func backupFiles(t *testing.T, dir string) []string {
+    files, err := ioutil.ReadDir(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, f := range files {
+        if f.Name() != "app.log" {
+            names = append(names, f.Name())
+        }
+    }
+    sort.Strings(names)
+    return names
+}
+
+func writeTestLines(s *fileSink, msgs ...string) {
+    for _, msg := range msgs {
+        s.write(&logMessage{severity: syslog.LOG_INFO, msg: msg, time: testTime})
+    }
+}
+
+func readTestFile(t *testing.T, path string) string {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(content)
+}
+
+// a line is "2019-01-02T15:04:05.123456Z INFO one\n", 37 bytes
+func TestFileSinkRotatesBySize(t *testing.T) {
+    s, dir := openTestFileSink(t, "maxsize=100&backups=2")
+    defer os.RemoveAll(dir)
+    // logrotate's backups aren't ours
+    ioutil.WriteFile(filepath.Join(dir, "app.log.1"), []byte("old\n"), 0644)
+    writeTestLines(s, "one", "two", "three", "four", "five", "six", "seven")
+    s.close()
+
+    backups := backupFiles(t, dir)
+    if len(backups) != 3 || backups[0] != "app.log.1" {
+        t.Fatalf("got files %v, expected app.log.1 and 2 backups", backups)
+    }
+    for _, name := range backups[1:] {
+        if !backupSuffix_re.MatchString(strings.TrimPrefix(name, "app.log")) {
+            t.Errorf("unexpected backup name %s", name)
+        }
+    }
+    if got := readTestFile(t, filepath.Join(dir, backups[2])); !strings.HasPrefix(got, "2019-01-02T15:04:05.123456Z INFO five\n") || !strings.HasSuffix(got, "INFO six\n") {
+        t.Errorf("got newest backup %q, expected five and six", got)
+    }
+    if got := readTestFile(t, filepath.Join(dir, "app.log")); got != "2019-01-02T15:04:05.123456Z INFO seven\n" {
+        t.Errorf("got %q", got)
+    }
+}
+
+func TestFileSinkRotatesByInterval(t *testing.T) {
+    s, dir := openTestFileSink(t, "interval=1h&compress=true")
+    defer os.RemoveAll(dir)
+    if next := time.Now().Truncate(time.Hour).Add(time.Hour); !s.rotateAt.Equal(next) {
+        t.Errorf("got rotation at %s, expected %s", s.rotateAt, next)
+    }
+    writeTestLines(s, "one")
+    s.rotateAt = time.Now().Add(-time.Second)
+    writeTestLines(s, "two")
+    s.close()
+
+    backups := backupFiles(t, dir)
+    if len(backups) != 1 || !strings.HasSuffix(backups[0], ".gz") {
+        t.Fatalf("got files %v, expected a compressed backup", backups)
+    }
+    f, err := os.Open(filepath.Join(dir, backups[0]))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    z, err := gzip.NewReader(f)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if content, _ := ioutil.ReadAll(z); string(content) != "2019-01-02T15:04:05.123456Z INFO one\n" {
+        t.Errorf("got backup %q", content)
+    }
+    if !s.rotateAt.After(time.Now()) {
+        t.Errorf("got next rotation at %s", s.rotateAt)
+    }
+}
+
+func TestFileSinkKeepsWritingWhenRotationFails(t *testing.T) {
+    s, dir := openTestFileSink(t, "maxsize=50")
+    defer os.RemoveAll(dir)
+    defer s.close()
+    writeTestLines(s, "one")
+    // the file can't be renamed when it was removed
+    os.Remove(s.path)
+    writeTestLines(s, "two", "three")
+    if s.size != 37+37+39 || !s.rotateFailed || s.retryRotateAt.Before(time.Now()) {
+        t.Errorf("got size %d, failed %v, retry at %s", s.size, s.rotateFailed, s.retryRotateAt)
+    }
+
+    // once the file is back the next rotation works
+    s.reopen()
+    s.retryRotateAt = time.Now().Add(-time.Second)
+    writeTestLines(s, "four", "five")
+    if backups := backupFiles(t, dir); len(backups) != 1 || s.rotateFailed {
+        t.Errorf("got files %v, failed %v, expected a backup", backups, s.rotateFailed)
+    }
+    if got := readTestFile(t, s.path); got != "2019-01-02T15:04:05.123456Z INFO five\n" {
+        t.Errorf("got %q", got)
+    }
+}
+
+func TestOpenFileSinkOptions(t *testing.T) {
+    for _, options := range []string{"format=xml", "maxsize=10X", "interval=0s", "backups=-1", "compress=maybe"} {
+        spec := "file:///tmp/pipe2log-test.log?" + options
+        uri, values, _ := parseDestination(spec)
+        if s, err := openFileSink(uri, values); err == nil {
+            s.close()
+            t.Errorf("%s: expected an error", options)
+        }
+    }
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// Filter rules are read from the -filters file, one rule per line. The
+// first matching rule decides if a message is kept or dropped, messages
+// not matching any rule are kept.
+//
+//   # drop health checks and metrics scraping
+//   drop @req.url = /health
+//   drop /metrics
+//
+// A rule is an action, 'drop' or 'keep', followed by a regular expression
+// matched against the message text, or by a json field condition starting
+// with '@'. Nested fields are separated by dots. A field condition is one
+// of '= value', '!= value', '~ regex', '!~ regex', or just the field name
+// to match if the field exists.
+
+var flagFilterFile string
+var flagDryRun bool
+
+type filterRule struct {
+    line int
+    text string
+    drop bool
+    re *regexp.Regexp
+    // json field condition
+    field []string
+    op string
+    value string
+}
+
+var fieldCondition_re = regexp.MustCompile(`^@([^ !=~]+)[ ]*(?:(=|!=|~|!~)[ ]*(.*))?$`)
+
+func parseFilterRule(line string) (*filterRule, error) {
+    rule := &filterRule{text: line}
+    var match string
+    if idx := strings.IndexAny(line, " \t"); idx > 0 {
+        match = strings.TrimSpace(line[idx:])
+        line = line[:idx]
+    }
+    switch line {
+    case "drop":
+        rule.drop = true
+    case "keep":
+        rule.drop = false
+    default:
+        return nil, fmt.Errorf("unknown action '%s', must be drop or keep", line)
+    }
+    if match == "" {
+        return nil, fmt.Errorf("missing regular expression or field condition")
+    }
+
+    var err error
+    if strings.HasPrefix(match, "@") {
+        rs := fieldCondition_re.FindStringSubmatch(match)
+        if rs == nil {
+            return nil, fmt.Errorf("invalid field condition '%s'", match)
+        }
+        rule.field = strings.Split(rs[1], ".")
+        rule.op = rs[2]
+        rule.value = rs[3]
+        if rule.op == "~" || rule.op == "!~" {
+            rule.re, err = regexp.Compile(rule.value)
+        }
+    } else {
+        rule.re, err = regexp.Compile(match)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return rule, nil
+}
+
+func loadFilterRules(path string) ([]*filterRule, error) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var filters []*filterRule
+    s := bufio.NewScanner(bytes.NewReader(content))
+    lineno := 0
+    for s.Scan() {
+        lineno++
+        line := strings.TrimSpace(s.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        rule, err := parseFilterRule(line)
+        if err != nil {
+            return nil, fmt.Errorf("%s:%d %s", path, lineno, err)
+        }
+        rule.line = lineno
+        filters = append(filters, rule)
+    }
+    return filters, s.Err()
+}
+
+// lookupField finds a nested field in the parsed json fields
+func lookupField(fields map[string]interface{}, path []string) (interface{}, bool) {
+    var value interface{} = fields
+    for _, name := range path {
+        obj, ok := value.(map[string]interface{})
+        if !ok {
+            return nil, false
+        }
+        value, ok = obj[name]
+        if !ok {
+            return nil, false
+        }
+    }
+    return value, true
+}
+
+// fieldString formats a json value for comparing, objects and arrays as json
+func fieldString(value interface{}) string {
+    switch v := value.(type) {
+    case string:
+        return v
+    case map[string]interface{}, []interface{}:
+        _byteArray, _ := json.Marshal(v)
+        return string(_byteArray)
+    case float64:
+        // json numbers, 1000000 and not 1e+06
+        return strconv.FormatFloat(v, 'f', -1, 64)
+    case nil:
+        return "null"
+    }
+    return fmt.Sprintf("%v", value)
+}
+
+func (r *filterRule) match(m *logMessage) bool {
+    if r.field == nil {
+        return r.re.MatchString(m.text())
+    }
+    value, ok := lookupField(m.fields, r.field)
+    switch r.op {
+    case "":
+        return ok
+    case "=":
+        return ok && fieldString(value) == r.value
+    case "!=":
+        return !ok || fieldString(value) != r.value
+    case "~":
+        return ok && r.re.MatchString(fieldString(value))
+    case "!~":
+        return !ok || !r.re.MatchString(fieldString(value))
+    }
+    return false
+}
+
+// matchFilterRules returns the first rule matching m, or nil
+func matchFilterRules(filters []*filterRule, m *logMessage) *filterRule {
+    for _, r := range filters {
+        if r.match(m) {
+            return r
+        }
+    }
+    return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+)
+
+func TestFilterRules(t *testing.T) {
+    var fields map[string]interface{}
+    json.Unmarshal([]byte(`{"req": {"url": "/health", "bytes": 1000000, "ms": 1.5}, "status": 200, "tags": ["a", "b"], "user": null, "cached": true}`), &fields)
+    m := &logMessage{msg: "GET /metrics", fields: fields}
+    tests := []struct {
+        rule string
+        matches bool
+    }{
+        {"drop /metrics", true},
+        {"drop ^POST", false},
+        {"drop @req.url = /health", true},
+        {"drop @req.url != /health", false},
+        {"drop @req.url ~ ^/heal", true},
+        {"drop @req.url !~ ^/heal", false},
+        {"drop @req.bytes = 1000000", true},
+        {"drop @req.bytes ~ ^1000000$", true},
+        {"drop @req.ms = 1.5", true},
+        {"drop @status = 200", true},
+        {"drop @status != 500", true},
+        {"drop @tags = [\"a\",\"b\"]", true},
+        {"drop @user = null", true},
+        {"drop @cached = true", true},
+        {"drop @req", true},
+        {"drop @req.method", false},
+        {"drop @req.method != GET", true},
+        {"drop @req.method !~ GET", true},
+        {"drop @req.url.path", false},
+    }
+    for _, test := range tests {
+        rule, err := parseFilterRule(test.rule)
+        if err != nil {
+            t.Errorf("%s: %s", test.rule, err)
+            continue
+        }
+        if got := rule.match(m); got != test.matches {
+            t.Errorf("%s: got %v, expected %v", test.rule, got, test.matches)
+        }
+    }
+}
+
+func TestParseFilterRuleErrors(t *testing.T) {
+    for _, line := range []string{"ignore /metrics", "drop", "drop (unclosed", "drop @req.url ~ (unclosed", "drop @"} {
+        if _, err := parseFilterRule(line); err == nil {
+            t.Errorf("%s: expected an error", line)
+        }
+    }
+}
+
+func writeTestFilters(t *testing.T, content string) string {
+    f, err := ioutil.TempFile("", "pipe2log-filters")
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.WriteString(content)
+    f.Close()
+    return f.Name()
+}
+
+func TestMatchFilterRules(t *testing.T) {
+    f := writeTestFilters(t, "# keep errors from the health check\nkeep ^ERROR\n\ndrop health\n")
+    defer os.Remove(f)
+    filters, err := loadFilterRules(f)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        msg string
+        line int
+        drop bool
+    }{
+        {"ERROR health check failed", 2, false},
+        {"health check ok", 4, true},
+        {"started", 0, false},
+    }
+    for _, test := range tests {
+        rule := matchFilterRules(filters, &logMessage{msg: test.msg})
+        switch {
+        case test.line == 0 && rule != nil:
+            t.Errorf("%s: matched %s", test.msg, rule.text)
+        case test.line != 0 && (rule == nil || rule.line != test.line || rule.drop != test.drop):
+            t.Errorf("%s: got %+v, expected line %d", test.msg, rule, test.line)
+        }
+    }
+
+    bad := writeTestFilters(t, "drop a\nremove b\n")
+    defer os.Remove(bad)
+    if _, err := loadFilterRules(bad); err == nil || !strings.Contains(err.Error(), ":2 unknown action 'remove'") {
+        t.Errorf("got %v", err)
+    }
+}
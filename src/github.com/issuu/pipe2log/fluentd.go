@@ -0,0 +1,409 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math"
+    "net"
+    url "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// forwardSink sends batches of messages with the Fluentd forward protocol
+// to fluentd or Fluent Bit, i.e.
+//   forward://aggregator:24224?ack=true
+// Messages are sent in PackedForward mode, one message per tag in a batch.
+// The tag is <prefix>.<appname>.<severity>. With ack the server confirms
+// every chunk, chunks not confirmed are sent again.
+type forwardSink struct {
+    address string
+    tagPrefix string
+    ack bool
+    timeout time.Duration
+    conn net.Conn
+    reader *bufio.Reader
+    batcher *batcher
+}
+
+const forwardDefaultPort = "24224"
+
+// the options of the forward destination
+var forwardOptionNames = []string{"tag", "ack", "timeout"}
+
+func openForwardSink(spec, uri string, options url.Values) (logSink, error) {
+    if err := checkOptions(spec, options, append(forwardOptionNames, batchOptionNames...)...); err != nil {
+        return nil, err
+    }
+    u, err := url.Parse(uri)
+    if err != nil {
+        return nil, err
+    }
+    if u.Host == "" {
+        return nil, fmt.Errorf("invalid forward destination '%s', use forward://host[:24224]", spec)
+    }
+    s := &forwardSink{address: u.Host, tagPrefix: options.Get("tag"), timeout: 30 * time.Second}
+    if strings.Index(s.address, ":") == -1 {
+        s.address += ":" + forwardDefaultPort
+    }
+    if v := options.Get("ack"); v != "" {
+        if s.ack, err = strconv.ParseBool(v); err != nil {
+            return nil, fmt.Errorf("invalid ack '%s' for destination '%s'", v, spec)
+        }
+    }
+    if v := options.Get("timeout"); v != "" {
+        if s.timeout, err = time.ParseDuration(v); err != nil {
+            return nil, fmt.Errorf("invalid timeout '%s' for destination '%s'", v, spec)
+        }
+    }
+    batchOptions, err := parseBatchOptions(spec, options)
+    if err != nil {
+        return nil, err
+    }
+    s.batcher = newBatcher(uri, batchOptions, s.send)
+    return s, nil
+}
+
+// fluentd tags are dot separated words
+func forwardTagPart(s string) string {
+    s = strings.TrimPrefix(s[strings.LastIndex(s, "/")+1:], ".")
+    return strings.Map(func(r rune) rune {
+        if r == '.' || r == ' ' {
+            return '_'
+        }
+        return r
+    }, s)
+}
+
+func (s *forwardSink) tag(m *logMessage) string {
+    tag := forwardTagPart(m.app()) + "." + severityNames[m.severity&0x07]
+    if s.tagPrefix != "" {
+        tag = s.tagPrefix + "." + tag
+    }
+    return tag
+}
+
+func (s *forwardSink) record(m *logMessage) map[string]interface{} {
+    record := map[string]interface{}{
+        "message": m.msg,
+        "severity": severityNames[m.severity&0x07],
+        "facility": m.facilityName(),
+        "hostname": m.host(),
+        "appname": m.app(),
+    }
+    if m.fields != nil {
+        record["fields"] = m.fields
+    }
+    if tags := tagMap(m.tags); tags != nil {
+        tagValues := make(map[string]interface{})
+        for name, value := range tags {
+            tagValues[name] = value
+        }
+        record["tags"] = tagValues
+    }
+    return record
+}
+
+func (s *forwardSink) dial() error {
+    conn, err := net.DialTimeout("tcp", s.address, 10*time.Second)
+    if err != nil {
+        return err
+    }
+    s.conn = conn
+    s.reader = bufio.NewReader(conn)
+    return nil
+}
+
+func (s *forwardSink) disconnect() {
+    if s.conn != nil {
+        s.conn.Close()
+        s.conn = nil
+    }
+}
+
+// send sends the messages of every tag as a PackedForward message, if
+// sending fails only the tags not sent yet are retried
+func (s *forwardSink) send(batch []*logMessage) error {
+    var tags []string
+    entries := make(map[string]*msgpackEncoder)
+    messages := make(map[string][]*logMessage)
+    for _, m := range batch {
+        tag := s.tag(m)
+        e := entries[tag]
+        if e == nil {
+            e = &msgpackEncoder{}
+            entries[tag] = e
+            tags = append(tags, tag)
+        }
+        e.arrayHeader(2)
+        e.eventTime(m.time)
+        e.value(s.record(m))
+        messages[tag] = append(messages[tag], m)
+    }
+    for i, tag := range tags {
+        err := s.sendEntries(tag, entries[tag].buf.Bytes())
+        if err != nil {
+            s.disconnect()
+            if i == 0 {
+                return err
+            }
+            var retry []*logMessage
+            for _, t := range tags[i:] {
+                retry = append(retry, messages[t]...)
+            }
+            return retryItems{messages: retry, err: err}
+        }
+    }
+    return nil
+}
+
+func (s *forwardSink) sendEntries(tag string, entries []byte) error {
+    if s.conn == nil {
+        if err := s.dial(); err != nil {
+            return err
+        }
+    }
+    e := &msgpackEncoder{}
+    e.arrayHeader(3)
+    e.str(tag)
+    e.bin(entries)
+    options := map[string]interface{}{"size": len(entries)}
+    var chunk string
+    if s.ack {
+        id := make([]byte, 16)
+        if _, err := rand.Read(id); err != nil {
+            return err
+        }
+        chunk = base64.StdEncoding.EncodeToString(id)
+        options["chunk"] = chunk
+    }
+    e.value(options)
+    s.conn.SetDeadline(time.Now().Add(s.timeout))
+    if _, err := s.conn.Write(e.buf.Bytes()); err != nil {
+        return err
+    }
+    if !s.ack {
+        return nil
+    }
+    response, err := readMsgpackStringMap(s.reader)
+    if err != nil {
+        return fmt.Errorf("no ack from %s: %s", s.address, err)
+    }
+    if response["ack"] != chunk {
+        return fmt.Errorf("unexpected ack from %s", s.address)
+    }
+    return nil
+}
+
+func (s *forwardSink) write(m *logMessage) error {
+    s.batcher.add(m)
+    return nil
+}
+
+func (s *forwardSink) close() error {
+    s.batcher.close()
+    s.disconnect()
+    return nil
+}
+
+// msgpackEncoder encodes the values of parsed json as MessagePack
+type msgpackEncoder struct {
+    buf bytes.Buffer
+}
+
+func (e *msgpackEncoder) uint(prefix byte, n uint64, size int) {
+    e.buf.WriteByte(prefix)
+    b := make([]byte, 8)
+    binary.BigEndian.PutUint64(b, n)
+    e.buf.Write(b[8-size:])
+}
+
+func (e *msgpackEncoder) arrayHeader(n int) {
+    switch {
+    case n < 16:
+        e.buf.WriteByte(0x90 | byte(n))
+    case n <= math.MaxUint16:
+        e.uint(0xdc, uint64(n), 2)
+    default:
+        e.uint(0xdd, uint64(n), 4)
+    }
+}
+
+func (e *msgpackEncoder) mapHeader(n int) {
+    switch {
+    case n < 16:
+        e.buf.WriteByte(0x80 | byte(n))
+    case n <= math.MaxUint16:
+        e.uint(0xde, uint64(n), 2)
+    default:
+        e.uint(0xdf, uint64(n), 4)
+    }
+}
+
+func (e *msgpackEncoder) str(s string) {
+    n := len(s)
+    switch {
+    case n < 32:
+        e.buf.WriteByte(0xa0 | byte(n))
+    case n <= math.MaxUint8:
+        e.uint(0xd9, uint64(n), 1)
+    case n <= math.MaxUint16:
+        e.uint(0xda, uint64(n), 2)
+    default:
+        e.uint(0xdb, uint64(n), 4)
+    }
+    e.buf.WriteString(s)
+}
+
+func (e *msgpackEncoder) bin(b []byte) {
+    n := len(b)
+    switch {
+    case n <= math.MaxUint8:
+        e.uint(0xc4, uint64(n), 1)
+    case n <= math.MaxUint16:
+        e.uint(0xc5, uint64(n), 2)
+    default:
+        e.uint(0xc6, uint64(n), 4)
+    }
+    e.buf.Write(b)
+}
+
+func (e *msgpackEncoder) int(n int64) {
+    switch {
+    case n >= 0 && n < 128:
+        e.buf.WriteByte(byte(n))
+    case n < 0 && n >= -32:
+        e.buf.WriteByte(byte(n))
+    case n >= 0:
+        e.uint(0xcf, uint64(n), 8)
+    default:
+        e.uint(0xd3, uint64(n), 8)
+    }
+}
+
+// eventTime is the fluentd EventTime extension, seconds and nanoseconds
+func (e *msgpackEncoder) eventTime(t time.Time) {
+    e.buf.Write([]byte{0xd7, 0x00})
+    b := make([]byte, 8)
+    binary.BigEndian.PutUint32(b, uint32(t.Unix()))
+    binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+    e.buf.Write(b)
+}
+
+func (e *msgpackEncoder) value(value interface{}) {
+    switch v := value.(type) {
+    case nil:
+        e.buf.WriteByte(0xc0)
+    case bool:
+        if v {
+            e.buf.WriteByte(0xc3)
+        } else {
+            e.buf.WriteByte(0xc2)
+        }
+    case int:
+        e.int(int64(v))
+    case int64:
+        e.int(v)
+    case float64:
+        // json numbers are float64, whole numbers are sent as integers
+        if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+            e.int(int64(v))
+        } else {
+            e.uint(0xcb, math.Float64bits(v), 8)
+        }
+    case string:
+        e.str(v)
+    case []interface{}:
+        e.arrayHeader(len(v))
+        for _, item := range v {
+            e.value(item)
+        }
+    case map[string]interface{}:
+        e.mapHeader(len(v))
+        for name, item := range v {
+            e.str(name)
+            e.value(item)
+        }
+    default:
+        e.str(fieldString(v))
+    }
+}
+
+// readMsgpackStringMap reads a map of strings, like the ack response
+func readMsgpackStringMap(r *bufio.Reader) (map[string]string, error) {
+    b, err := r.ReadByte()
+    if err != nil {
+        return nil, err
+    }
+    var n int
+    switch {
+    case b&0xf0 == 0x80:
+        n = int(b & 0x0f)
+    case b == 0xde:
+        n, err = readMsgpackLength(r, 2)
+    default:
+        return nil, fmt.Errorf("unexpected msgpack type 0x%02x", b)
+    }
+    if err != nil {
+        return nil, err
+    }
+    result := make(map[string]string)
+    for i := 0; i < n; i++ {
+        key, err := readMsgpackString(r)
+        if err != nil {
+            return nil, err
+        }
+        value, err := readMsgpackString(r)
+        if err != nil {
+            return nil, err
+        }
+        result[key] = value
+    }
+    return result, nil
+}
+
+func readMsgpackLength(r *bufio.Reader, size int) (int, error) {
+    b := make([]byte, size)
+    if _, err := io.ReadFull(r, b); err != nil {
+        return 0, err
+    }
+    n := 0
+    for _, c := range b {
+        n = n<<8 | int(c)
+    }
+    return n, nil
+}
+
+func readMsgpackString(r *bufio.Reader) (string, error) {
+    b, err := r.ReadByte()
+    if err != nil {
+        return "", err
+    }
+    var n int
+    switch {
+    case b&0xe0 == 0xa0:
+        n = int(b & 0x1f)
+    case b == 0xd9 || b == 0xc4:
+        n, err = readMsgpackLength(r, 1)
+    case b == 0xda || b == 0xc5:
+        n, err = readMsgpackLength(r, 2)
+    case b == 0xdb || b == 0xc6:
+        n, err = readMsgpackLength(r, 4)
+    default:
+        return "", fmt.Errorf("unexpected msgpack type 0x%02x", b)
+    }
+    if err != nil {
+        return "", err
+    }
+    s := make([]byte, n)
+    if _, err = io.ReadFull(r, s); err != nil {
+        return "", err
+    }
+    return string(s), nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/hex"
+    "net"
+    "strings"
+    "sync"
+    "testing"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestMsgpackEncoder(t *testing.T) {
+    tests := []struct {
+        name string
+        encode func(e *msgpackEncoder)
+        want string
+    }{
+        {"nil", func(e *msgpackEncoder) { e.value(nil) }, "c0"},
+        {"true", func(e *msgpackEncoder) { e.value(true) }, "c3"},
+        {"false", func(e *msgpackEncoder) { e.value(false) }, "c2"},
+        {"positive fixint", func(e *msgpackEncoder) { e.value(1) }, "01"},
+        {"negative fixint", func(e *msgpackEncoder) { e.value(-1) }, "ff"},
+        {"int64", func(e *msgpackEncoder) { e.value(int64(-33)) }, "d3ffffffffffffffdf"},
+        {"uint64", func(e *msgpackEncoder) { e.value(200) }, "cf00000000000000c8"},
+        {"whole float", func(e *msgpackEncoder) { e.value(float64(3)) }, "03"},
+        {"float", func(e *msgpackEncoder) { e.value(1.5) }, "cb3ff8000000000000"},
+        {"fixstr", func(e *msgpackEncoder) { e.value("a") }, "a161"},
+        {"str8", func(e *msgpackEncoder) { e.value(strings.Repeat("a", 32)) }, "d920" + strings.Repeat("61", 32)},
+        {"bin8", func(e *msgpackEncoder) { e.bin([]byte{1, 2, 3}) }, "c403010203"},
+        {"array", func(e *msgpackEncoder) { e.value([]interface{}{1, "a"}) }, "9201a161"},
+        {"array16", func(e *msgpackEncoder) { e.arrayHeader(16) }, "dc0010"},
+        {"map", func(e *msgpackEncoder) { e.value(map[string]interface{}{"a": 1}) }, "81a16101"},
+        {"map32", func(e *msgpackEncoder) { e.mapHeader(70000) }, "df00011170"},
+        {"event time", func(e *msgpackEncoder) { e.eventTime(testTime) }, "d7005c2cd2e5075bca00"},
+    }
+    for _, test := range tests {
+        e := &msgpackEncoder{}
+        test.encode(e)
+        if got := hex.EncodeToString(e.buf.Bytes()); got != test.want {
+            t.Errorf("%s: got %s, expected %s", test.name, got, test.want)
+        }
+    }
+}
+
+func TestReadMsgpackStringMap(t *testing.T) {
+    e := &msgpackEncoder{}
+    e.value(map[string]interface{}{"ack": strings.Repeat("x", 40)})
+    got, err := readMsgpackStringMap(bufio.NewReader(&e.buf))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(got) != 1 || got["ack"] != strings.Repeat("x", 40) {
+        t.Errorf("got %v", got)
+    }
+    if _, err = readMsgpackStringMap(bufio.NewReader(bytes.NewReader([]byte{0x01}))); err == nil {
+        t.Errorf("expected an error for a number")
+    }
+}
+
+// forwardServer accepts forward messages and acks their chunks, the
+// first acks answered with a wrong chunk id.
+type forwardServer struct {
+    listener net.Listener
+    wrongAcks int
+    mutex sync.Mutex
+    chunks [][]byte
+    done sync.WaitGroup
+}
+
+func newForwardServer(t *testing.T, wrongAcks int) *forwardServer {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := &forwardServer{listener: l, wrongAcks: wrongAcks}
+    s.done.Add(1)
+    go s.accept()
+    return s
+}
+
+func (s *forwardServer) accept() {
+    defer s.done.Done()
+    for {
+        conn, err := s.listener.Accept()
+        if err != nil {
+            return
+        }
+        s.done.Add(1)
+        go s.serve(conn)
+    }
+}
+
+// serve reads messages up to the chunk option, which is the last thing
+// sent, and answers with its ack
+func (s *forwardServer) serve(conn net.Conn) {
+    defer s.done.Done()
+    defer conn.Close()
+    marker := []byte("\xa5chunk")
+    var data []byte
+    buf := make([]byte, 4096)
+    for {
+        n, err := conn.Read(buf)
+        if err != nil {
+            return
+        }
+        data = append(data, buf[:n]...)
+        idx := bytes.Index(data, marker)
+        if idx < 0 {
+            continue
+        }
+        r := bufio.NewReader(bytes.NewReader(data[idx+len(marker):]))
+        chunk, err := readMsgpackString(r)
+        if err != nil {
+            continue
+        }
+        s.mutex.Lock()
+        wrong := s.wrongAcks > 0
+        if wrong {
+            s.wrongAcks--
+            chunk = "wrong"
+        } else {
+            s.chunks = append(s.chunks, data)
+        }
+        s.mutex.Unlock()
+        e := &msgpackEncoder{}
+        e.value(map[string]interface{}{"ack": chunk})
+        conn.Write(e.buf.Bytes())
+        data = nil
+    }
+}
+
+func (s *forwardServer) close() {
+    s.listener.Close()
+}
+
+func TestForwardSinkAck(t *testing.T) {
+    tests := []struct {
+        name string
+        wrongAcks int
+    }{
+        {"acked", 0},
+        {"resent after a wrong ack", 1},
+    }
+    for _, test := range tests {
+        server := newForwardServer(t, test.wrongAcks)
+        sink := openTestSink(t, openForwardSink, "forward://"+server.listener.Addr().String()+"?ack=true&tag=test&retries=1")
+        sink.write(&logMessage{severity: syslog.LOG_INFO, msg: "one", time: testTime, hostname: "web1", appname: "app"})
+        sink.write(&logMessage{severity: syslog.LOG_ERR, msg: "two", time: testTime, hostname: "web1", appname: "app"})
+        sink.close()
+        server.close()
+        server.done.Wait()
+
+        // one chunk per tag
+        if len(server.chunks) != 2 {
+            t.Errorf("%s: got %d chunks, expected 2", test.name, len(server.chunks))
+            continue
+        }
+        for i, tag := range []string{"test.app.info", "test.app.err"} {
+            if !bytes.Contains(server.chunks[i], []byte(tag)) {
+                t.Errorf("%s: chunk %d has no tag %s", test.name, i, tag)
+            }
+        }
+    }
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "compress/zlib"
+    "crypto/rand"
+    "encoding/json"
+    "fmt"
+    "net"
+    url "net/url"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// gelfSink sends GELF 1.1 messages to Graylog, i.e.
+//   gelf://graylog:12201?transport=tcp
+// Over udp messages are optionally compressed and split in chunks, over
+// tcp they are separated by a null byte and a write that doesn't finish
+// within the timeout fails. The parsed json fields and the
+// tags are sent as additional _fields, nested objects are flattened with
+// an underscore.
+type gelfSink struct {
+    transport string
+    address string
+    compress string
+    chunkSize int
+    timeout time.Duration
+    conn net.Conn
+}
+
+const gelfDefaultPort = "12201"
+
+// GELF udp chunk header: magic bytes, message id, sequence number and count
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+const gelfChunkHeaderSize = 12
+const gelfMaxChunks = 128
+
+var gelfFieldName_re = regexp.MustCompile(`[^\w.\-]`)
+
+func openGelfSink(uri string, options url.Values) (logSink, error) {
+    u, err := url.Parse(uri)
+    if err != nil {
+        return nil, err
+    }
+    if u.Host == "" {
+        return nil, fmt.Errorf("invalid gelf destination '%s', use gelf://host[:port]", uri)
+    }
+    s := &gelfSink{address: u.Host, transport: options.Get("transport"), compress: options.Get("compress"), chunkSize: 1420, timeout: 30 * time.Second}
+    if strings.Index(s.address, ":") == -1 {
+        s.address += ":" + gelfDefaultPort
+    }
+    switch s.transport {
+    case "":
+        s.transport = "udp"
+    case "udp", "tcp":
+    default:
+        return nil, fmt.Errorf("Unsupported transport '%s' for destination '%s', udp and tcp are supported.", s.transport, uri)
+    }
+    switch s.compress {
+    case "", "none", "gzip", "zlib":
+    default:
+        return nil, fmt.Errorf("Unsupported compress '%s' for destination '%s', none, gzip and zlib are supported.", s.compress, uri)
+    }
+    if s.transport == "tcp" && s.compress != "" && s.compress != "none" {
+        return nil, fmt.Errorf("compression is not supported over tcp for destination '%s'", uri)
+    }
+    if v := options.Get("chunksize"); v != "" {
+        s.chunkSize, err = strconv.Atoi(v)
+        if err != nil || s.chunkSize <= gelfChunkHeaderSize {
+            return nil, fmt.Errorf("invalid chunksize '%s' for destination '%s'", v, uri)
+        }
+    }
+    if v := options.Get("timeout"); v != "" {
+        if s.timeout, err = time.ParseDuration(v); err != nil || s.timeout <= 0 {
+            return nil, fmt.Errorf("invalid timeout '%s' for destination '%s'", v, uri)
+        }
+    }
+    if err = s.dial(); err != nil {
+        return nil, err
+    }
+    return s, nil
+}
+
+func (s *gelfSink) dial() error {
+    conn, err := net.DialTimeout(s.transport, s.address, 10*time.Second)
+    if err != nil {
+        return err
+    }
+    s.conn = conn
+    return nil
+}
+
+// addGelfFields adds the json fields as additional fields, GELF only
+// allows strings and numbers as values.
+func addGelfFields(gelf map[string]interface{}, prefix string, fields map[string]interface{}) {
+    for name, value := range fields {
+        name = prefix + gelfFieldName_re.ReplaceAllString(name, "_")
+        switch v := value.(type) {
+        case map[string]interface{}:
+            addGelfFields(gelf, name+"_", v)
+        case string, float64, int, int64:
+            gelf[name] = v
+        default:
+            gelf[name] = fieldString(v)
+        }
+    }
+}
+
+func formatGELF(m *logMessage) []byte {
+    short := m.msg
+    if idx := strings.IndexByte(short, '\n'); idx >= 0 {
+        short = short[:idx]
+    }
+    gelf := make(map[string]interface{})
+    addGelfFields(gelf, "_", m.fields)
+    for _, t := range m.tags {
+        gelf["_"+gelfFieldName_re.ReplaceAllString(t.name, "_")] = t.value
+    }
+    // _id is reserved
+    if id, ok := gelf["_id"]; ok {
+        gelf["_id_"] = id
+        delete(gelf, "_id")
+    }
+    gelf["version"] = "1.1"
+    gelf["host"] = m.host()
+    gelf["short_message"] = short
+    if short != m.msg {
+        gelf["full_message"] = m.msg
+    }
+    gelf["timestamp"] = float64(m.time.UnixNano()/int64(time.Millisecond)) / 1000
+    gelf["level"] = int(m.severity & 0x07)
+    gelf["_appname"] = m.app()
+    _byteArray, _ := json.Marshal(gelf)
+    return _byteArray
+}
+
+func gelfCompress(data []byte, compress string) ([]byte, error) {
+    var buf bytes.Buffer
+    var err error
+    switch compress {
+    case "gzip":
+        z := gzip.NewWriter(&buf)
+        if _, err = z.Write(data); err == nil {
+            err = z.Close()
+        }
+    case "zlib":
+        z := zlib.NewWriter(&buf)
+        if _, err = z.Write(data); err == nil {
+            err = z.Close()
+        }
+    default:
+        return data, nil
+    }
+    return buf.Bytes(), err
+}
+
+// gelfChunks splits a udp message into GELF chunks if it is too large
+func gelfChunks(data []byte, chunkSize int) ([][]byte, error) {
+    if len(data) <= chunkSize {
+        return [][]byte{data}, nil
+    }
+    payload := chunkSize - gelfChunkHeaderSize
+    count := (len(data) + payload - 1) / payload
+    if count > gelfMaxChunks {
+        return nil, fmt.Errorf("gelf message of %d bytes is too large, more than %d chunks", len(data), gelfMaxChunks)
+    }
+    id := make([]byte, 8)
+    if _, err := rand.Read(id); err != nil {
+        return nil, err
+    }
+    var chunks [][]byte
+    for i := 0; i < count; i++ {
+        end := (i + 1) * payload
+        if end > len(data) {
+            end = len(data)
+        }
+        chunk := make([]byte, 0, gelfChunkHeaderSize+end-i*payload)
+        chunk = append(chunk, gelfChunkMagic...)
+        chunk = append(chunk, id...)
+        chunk = append(chunk, byte(i), byte(count))
+        chunk = append(chunk, data[i*payload:end]...)
+        chunks = append(chunks, chunk)
+    }
+    return chunks, nil
+}
+
+func (s *gelfSink) send(data []byte) error {
+    if s.conn == nil {
+        if err := s.dial(); err != nil {
+            return err
+        }
+    }
+    if s.transport == "tcp" {
+        // a stalled server must not block the other destinations
+        s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+        _, err := s.conn.Write(append(data, 0))
+        return err
+    }
+    chunks, err := gelfChunks(data, s.chunkSize)
+    if err != nil {
+        return err
+    }
+    for _, chunk := range chunks {
+        if _, err = s.conn.Write(chunk); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *gelfSink) write(m *logMessage) error {
+    data, err := gelfCompress(formatGELF(m), s.compress)
+    if err != nil {
+        return err
+    }
+    err = s.send(data)
+    if err != nil && s.transport == "tcp" {
+        // try once more on a new connection
+        s.conn.Close()
+        s.conn = nil
+        err = s.send(data)
+    }
+    return err
+}
+
+func (s *gelfSink) close() error {
+    if s.conn == nil {
+        return nil
+    }
+    return s.conn.Close()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "compress/zlib"
+    "encoding/json"
+    "io/ioutil"
+    "math/rand"
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func openTestGelfSink(t *testing.T, spec string) *gelfSink {
+    uri, options, err := parseDestination(spec)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sink, err := openGelfSink(uri, options)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return sink.(*gelfSink)
+}
+
+func listenTestUDP(t *testing.T) *net.UDPConn {
+    conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+    if err != nil {
+        t.Fatal(err)
+    }
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    return conn
+}
+
+func TestGelfChunks(t *testing.T) {
+    data := make([]byte, 100)
+    for i := range data {
+        data[i] = byte(i)
+    }
+    if chunks, _ := gelfChunks(data, 100); len(chunks) != 1 || !bytes.Equal(chunks[0], data) {
+        t.Errorf("got %d chunks for a message that fits", len(chunks))
+    }
+    // 30 bytes of the message per chunk
+    chunks, err := gelfChunks(data, 42)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(chunks) != 4 {
+        t.Fatalf("got %d chunks, expected 4", len(chunks))
+    }
+    var joined []byte
+    for i, chunk := range chunks {
+        if !bytes.Equal(chunk[:2], gelfChunkMagic) || !bytes.Equal(chunk[2:10], chunks[0][2:10]) || chunk[10] != byte(i) || chunk[11] != 4 {
+            t.Errorf("chunk %d: got header %x", i, chunk[:gelfChunkHeaderSize])
+        }
+        joined = append(joined, chunk[gelfChunkHeaderSize:]...)
+    }
+    if len(chunks[3]) != gelfChunkHeaderSize+10 || !bytes.Equal(joined, data) {
+        t.Errorf("got %x, expected %x", joined, data)
+    }
+    if _, err := gelfChunks(make([]byte, 30*gelfMaxChunks+1), 42); err == nil {
+        t.Errorf("expected an error for more than %d chunks", gelfMaxChunks)
+    }
+}
+
+func TestGelfSinkUDPCompressed(t *testing.T) {
+    server := listenTestUDP(t)
+    defer server.Close()
+    s := openTestGelfSink(t, "gelf://"+server.LocalAddr().String()+"?compress=gzip")
+    defer s.close()
+    m := &logMessage{severity: syslog.LOG_ERR, time: testTime, hostname: "web1", appname: "app", msg: "failed\nstack",
+        fields: map[string]interface{}{"id": "abc", "req": map[string]interface{}{"url": "/", "status": 500.0}},
+        tags: []tag{{"env", "prod"}}}
+    if err := s.write(m); err != nil {
+        t.Fatal(err)
+    }
+    packet := make([]byte, 65536)
+    n, err := server.Read(packet)
+    if err != nil {
+        t.Fatal(err)
+    }
+    z, err := gzip.NewReader(bytes.NewReader(packet[:n]))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var gelf map[string]interface{}
+    if err = json.NewDecoder(z).Decode(&gelf); err != nil {
+        t.Fatal(err)
+    }
+    expected := map[string]interface{}{"version": "1.1", "host": "web1", "short_message": "failed", "full_message": "failed\nstack",
+        "timestamp": 1546441445.123, "level": 3.0, "_appname": "app", "_id_": "abc", "_req_url": "/", "_req_status": 500.0, "_env": "prod"}
+    if len(gelf) != len(expected) {
+        t.Errorf("got %v, expected %v", gelf, expected)
+    }
+    for name, value := range expected {
+        if gelf[name] != value {
+            t.Errorf("%s: got %v, expected %v", name, gelf[name], value)
+        }
+    }
+}
+
+func TestGelfSinkUDPChunked(t *testing.T) {
+    server := listenTestUDP(t)
+    defer server.Close()
+    s := openTestGelfSink(t, "gelf://"+server.LocalAddr().String()+"?compress=zlib&chunksize=512")
+    defer s.close()
+    // random letters don't compress to a single chunk
+    letters := make([]byte, 4000)
+    r := rand.New(rand.NewSource(1))
+    for i := range letters {
+        letters[i] = byte('a' + r.Intn(26))
+    }
+    if err := s.write(&logMessage{severity: syslog.LOG_INFO, time: testTime, msg: string(letters)}); err != nil {
+        t.Fatal(err)
+    }
+
+    var data []byte
+    packet := make([]byte, 65536)
+    for count := 1; count > 0; count-- {
+        n, err := server.Read(packet)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if n > 512 || !bytes.Equal(packet[:2], gelfChunkMagic) {
+            t.Fatalf("got a packet of %d bytes starting with %x", n, packet[:2])
+        }
+        // udp on the loopback keeps the order
+        if len(data) == 0 {
+            count = int(packet[11])
+        }
+        data = append(data, packet[gelfChunkHeaderSize:n]...)
+    }
+    z, err := zlib.NewReader(bytes.NewReader(data))
+    if err != nil {
+        t.Fatal(err)
+    }
+    content, _ := ioutil.ReadAll(z)
+    if !strings.Contains(string(content), `"short_message":"`+string(letters)+`"`) {
+        t.Errorf("got %.100s", content)
+    }
+}
+
+func TestGelfSinkTCPWriteTimeout(t *testing.T) {
+    server, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer server.Close()
+    // the server accepts connections but never reads
+    go func() {
+        for {
+            conn, err := server.Accept()
+            if err != nil {
+                return
+            }
+            defer conn.Close()
+        }
+    }()
+    s := openTestGelfSink(t, "gelf://"+server.Addr().String()+"?transport=tcp&timeout=100ms")
+    defer s.close()
+
+    // more than fits in the socket buffers, a write that times out is
+    // sent again on a new connection
+    done := make(chan bool)
+    go func() {
+        m := &logMessage{severity: syslog.LOG_INFO, time: testTime, msg: strings.Repeat("x", 1024*1024)}
+        for i := 0; i < 32; i++ {
+            s.write(m)
+        }
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-time.After(20 * time.Second):
+        t.Fatal("the write to a stalled server is blocked")
+    }
+}
+
+func TestOpenGelfSinkOptions(t *testing.T) {
+    for _, options := range []string{"transport=sctp", "compress=lz4", "transport=tcp&compress=gzip", "chunksize=12", "timeout=0s", "timeout=soon"} {
+        uri, values, _ := parseDestination("gelf://127.0.0.1?" + options)
+        if s, err := openGelfSink(uri, values); err == nil {
+            s.close()
+            t.Errorf("%s: expected an error", options)
+        }
+    }
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    url "net/url"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// httpSink posts batches of messages as json, i.e.
+//   https://logs.example.com/ingest?header=Authorization:Bearer%20xyz
+// In the default ndjson mode every message is a line of json, like the
+// json lines output. In loki mode the batch is sent to the Loki push api,
+// http://loki:3100/loki/api/v1/push?mode=loki, with the messages grouped
+// in streams by their labels.
+type httpSink struct {
+    mode string
+    labels []string
+    poster *httpPoster
+    batcher *batcher
+}
+
+// the options of the http destination, other options are sent to the server
+var httpSinkOptionNames = []string{"mode", "labels"}
+
+func openHTTPSink(spec, uri string, options url.Values) (logSink, error) {
+    s := &httpSink{mode: options.Get("mode"), labels: []string{"appname", "hostname", "severity"}}
+    switch s.mode {
+    case "":
+        s.mode = "ndjson"
+    case "ndjson", "loki":
+    default:
+        return nil, fmt.Errorf("Unsupported mode '%s' for destination '%s', ndjson and loki are supported.", s.mode, spec)
+    }
+    if v := options.Get("labels"); v != "" {
+        s.labels = splitList(v)
+    }
+    batchOptions, err := parseBatchOptions(spec, options)
+    if err != nil {
+        return nil, err
+    }
+    s.poster, err = newHTTPPoster(spec, uri, options, httpSinkOptionNames...)
+    if err != nil {
+        return nil, err
+    }
+    s.batcher = newBatcher(uri, batchOptions, s.send)
+    return s, nil
+}
+
+// lokiLabels returns the labels of the stream of m, tags can be used as
+// labels by name.
+func (s *httpSink) lokiLabels(m *logMessage) map[string]string {
+    labels := make(map[string]string)
+    for _, name := range s.labels {
+        switch name {
+        case "appname":
+            labels[name] = m.app()
+        case "hostname":
+            labels[name] = m.host()
+        case "severity":
+            labels[name] = severityNames[m.severity&0x07]
+        default:
+            for _, t := range m.tags {
+                if t.name == name {
+                    labels[name] = t.value
+                }
+            }
+        }
+    }
+    return labels
+}
+
+type lokiStream struct {
+    Stream map[string]string `json:"stream"`
+    Values [][2]string `json:"values"`
+}
+
+func (s *httpSink) encodeLoki(batch []*logMessage) []byte {
+    var streams []*lokiStream
+    byKey := make(map[string]*lokiStream)
+    for _, m := range batch {
+        labels := s.lokiLabels(m)
+        var key []string
+        for name, value := range labels {
+            key = append(key, name+"="+value)
+        }
+        sort.Strings(key)
+        stream := byKey[strings.Join(key, ",")]
+        if stream == nil {
+            stream = &lokiStream{Stream: labels}
+            byKey[strings.Join(key, ",")] = stream
+            streams = append(streams, stream)
+        }
+        stream.Values = append(stream.Values, [2]string{strconv.FormatInt(m.time.UnixNano(), 10), m.text()})
+    }
+    _byteArray, _ := json.Marshal(map[string]interface{}{"streams": streams})
+    return _byteArray
+}
+
+func (s *httpSink) send(batch []*logMessage) error {
+    if s.mode == "loki" {
+        _, err := s.poster.post("", s.encodeLoki(batch), "application/json")
+        return err
+    }
+    var body bytes.Buffer
+    for _, m := range batch {
+        body.Write(formatJSON(m))
+        body.WriteByte('\n')
+    }
+    _, err := s.poster.post("", body.Bytes(), "application/x-ndjson")
+    return err
+}
+
+func (s *httpSink) write(m *logMessage) error {
+    s.batcher.add(m)
+    return nil
+}
+
+func (s *httpSink) close() error {
+    s.batcher.close()
+    return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    url "net/url"
+    "sync"
+    "testing"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+var testTime = time.Date(2019, 1, 2, 15, 4, 5, 123456000, time.UTC)
+
+// testServer records the requests it gets and answers with the responses
+// of respond, or 200 without a body.
+type testServer struct {
+    *httptest.Server
+    mutex sync.Mutex
+    requests []*http.Request
+    bodies [][]byte
+    respond func(r *http.Request, body []byte) (int, string)
+}
+
+func newTestServer(respond func(r *http.Request, body []byte) (int, string)) *testServer {
+    s := &testServer{respond: respond}
+    s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := ioutil.ReadAll(r.Body)
+        if r.Header.Get("Content-Encoding") == "gzip" {
+            z, err := gzip.NewReader(bytes.NewReader(body))
+            if err == nil {
+                body, _ = ioutil.ReadAll(z)
+            }
+        }
+        s.mutex.Lock()
+        s.requests = append(s.requests, r)
+        s.bodies = append(s.bodies, body)
+        s.mutex.Unlock()
+        status, response := http.StatusOK, ""
+        if s.respond != nil {
+            status, response = s.respond(r, body)
+        }
+        w.WriteHeader(status)
+        w.Write([]byte(response))
+    }))
+    return s
+}
+
+// openTestSink opens a sink like openDestination does with open
+func openTestSink(t *testing.T, open func(spec, uri string, options url.Values) (logSink, error), spec string) logSink {
+    uri, options, err := parseDestination(spec)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sink, err := open(spec, uri, options)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return sink
+}
+
+func TestHTTPSinkNDJSON(t *testing.T) {
+    server := newTestServer(nil)
+    defer server.Close()
+    sink := openTestSink(t, openHTTPSink, server.URL+"/ingest?gzip=true&header=Authorization:Bearer%20xyz&dataset=logs")
+    messages := []*logMessage{
+        {severity: syslog.LOG_INFO, msg: "one", time: testTime, hostname: "web1", appname: "app"},
+        {severity: syslog.LOG_WARNING, msg: "two", time: testTime, hostname: "web1", appname: "app"},
+    }
+    for _, m := range messages {
+        sink.write(m)
+    }
+    sink.close()
+
+    if len(server.requests) != 1 {
+        t.Fatalf("got %d requests, expected 1", len(server.requests))
+    }
+    r := server.requests[0]
+    if r.URL.Path != "/ingest" || r.URL.RawQuery != "dataset=logs" {
+        t.Errorf("posted to %s, expected /ingest?dataset=logs", r.URL)
+    }
+    if got := r.Header.Get("Authorization"); got != "Bearer xyz" {
+        t.Errorf("got Authorization %q", got)
+    }
+    if got := r.Header.Get("Content-Type"); got != "application/x-ndjson" {
+        t.Errorf("got Content-Type %q", got)
+    }
+    var want []byte
+    for _, m := range messages {
+        want = append(append(want, formatJSON(m)...), '\n')
+    }
+    if !bytes.Equal(server.bodies[0], want) {
+        t.Errorf("got body %s, expected %s", server.bodies[0], want)
+    }
+}
+
+func TestHTTPSinkLoki(t *testing.T) {
+    server := newTestServer(nil)
+    defer server.Close()
+    sink := openTestSink(t, openHTTPSink, server.URL+"/loki/api/v1/push?mode=loki&labels=appname,severity")
+    for _, m := range []*logMessage{
+        {severity: syslog.LOG_INFO, msg: "one", time: testTime, hostname: "web1", appname: "app"},
+        {severity: syslog.LOG_ERR, msg: "two", time: testTime, hostname: "web1", appname: "app"},
+        {severity: syslog.LOG_INFO, msg: "three", time: testTime, hostname: "web1", appname: "app"},
+    } {
+        sink.write(m)
+    }
+    sink.close()
+
+    if len(server.bodies) != 1 {
+        t.Fatalf("got %d requests, expected 1", len(server.bodies))
+    }
+    var push struct {
+        Streams []lokiStream `json:"streams"`
+    }
+    if err := json.Unmarshal(server.bodies[0], &push); err != nil {
+        t.Fatal(err)
+    }
+    if len(push.Streams) != 2 {
+        t.Fatalf("got %d streams, expected 2: %s", len(push.Streams), server.bodies[0])
+    }
+    info, errors := push.Streams[0], push.Streams[1]
+    if info.Stream["appname"] != "app" || info.Stream["severity"] != "info" || len(info.Stream) != 2 {
+        t.Errorf("got labels %v", info.Stream)
+    }
+    if len(info.Values) != 2 || info.Values[0][1] != "one" || info.Values[1][1] != "three" {
+        t.Errorf("got values %v", info.Values)
+    }
+    if info.Values[0][0] != "1546441445123456000" {
+        t.Errorf("got timestamp %s", info.Values[0][0])
+    }
+    if errors.Stream["severity"] != "err" || len(errors.Values) != 1 || errors.Values[0][1] != "two" {
+        t.Errorf("got stream %+v", errors)
+    }
+}
+
+func TestHTTPPosterErrors(t *testing.T) {
+    tests := []struct {
+        status int
+        permanent bool
+    }{
+        {http.StatusBadRequest, true},
+        {http.StatusTooManyRequests, false},
+        {http.StatusServiceUnavailable, false},
+    }
+    for _, test := range tests {
+        server := newTestServer(func(r *http.Request, body []byte) (int, string) {
+            return test.status, "no"
+        })
+        uri, options, _ := parseDestination(server.URL)
+        p, err := newHTTPPoster(server.URL, uri, options)
+        if err != nil {
+            t.Fatal(err)
+        }
+        _, err = p.post("", []byte("{}"), "application/json")
+        server.Close()
+        if err == nil {
+            t.Errorf("%d: expected an error", test.status)
+            continue
+        }
+        if _, permanent := err.(permanentError); permanent != test.permanent {
+            t.Errorf("%d: got permanent %v, expected %v", test.status, permanent, test.permanent)
+        }
+    }
+}
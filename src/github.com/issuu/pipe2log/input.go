@@ -0,0 +1,266 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    url "net/url"
+    "os"
+    "sync"
+    "sync/atomic"
+    "syscall"
+)
+
+// With -input pipe2log reads from named pipes and unix sockets instead of
+// a pipe, each with its own logformat and appname, i.e.
+//   -input 'fifo:///run/app.fifo?format=pino&appname=web,unix:///run/pipe2log.sock'
+// A fifo is created if missing and opened again when the last writer
+// closes it. Many processes can write to a unix stream socket at the
+// same time, a unixgram socket takes messages as datagrams.
+
+var flagInput string
+
+// the options of an input
+var inputOptionNames = []string{"format", "appname"}
+
+// inputSource is where scanned data comes from
+type inputSource struct {
+    spec string
+    format string
+    appname string
+    closed int32
+    closer io.Closer
+    // the accepted connections of a unix stream socket
+    mutex sync.Mutex
+    conns map[net.Conn]bool
+}
+
+// logformat is the format of the data from the source
+func (source *inputSource) logformat() string {
+    if source == nil || source.format == "" {
+        return flagLogformat
+    }
+    return source.format
+}
+
+func (source *inputSource) split(data []byte, atEOF bool) (advance int, scantoken []byte, err error) {
+    if source.format == "" {
+        return scanSplit(data, atEOF)
+    }
+    if jsonLogformat(source.format) {
+        return ScanJSON(data, atEOF)
+    }
+    return ScanLines(data, atEOF)
+}
+
+// scan sends the data read from r to dc until the end or an error
+func (source *inputSource) scan(dc chan scandata, r io.Reader) error {
+    // the clients of a stream socket are told apart by their connection
+    conn, _ := r.(net.Conn)
+    s := bufio.NewScanner(r)
+    s.Split(source.split)
+    for s.Scan() {
+        data := make([]byte, len(s.Bytes()))
+        copy(data, s.Bytes())
+        dc <- scandata{data: data, source: source, conn: conn}
+    }
+    return s.Err()
+}
+
+func (source *inputSource) isClosed() bool {
+    return atomic.LoadInt32(&source.closed) != 0
+}
+
+func (source *inputSource) close() {
+    atomic.StoreInt32(&source.closed, 1)
+    if source.closer != nil {
+        source.closer.Close()
+    }
+    source.mutex.Lock()
+    defer source.mutex.Unlock()
+    for conn := range source.conns {
+        conn.Close()
+    }
+    source.conns = nil
+}
+
+// track adds an accepted connection, false when the source is closed
+func (source *inputSource) track(conn net.Conn) bool {
+    source.mutex.Lock()
+    defer source.mutex.Unlock()
+    if source.isClosed() {
+        return false
+    }
+    if source.conns == nil {
+        source.conns = make(map[net.Conn]bool)
+    }
+    source.conns[conn] = true
+    return true
+}
+
+func (source *inputSource) untrack(conn net.Conn) {
+    source.mutex.Lock()
+    defer source.mutex.Unlock()
+    delete(source.conns, conn)
+}
+
+func (source *inputSource) logError(err error) {
+    if !source.isClosed() {
+        log.Printf("%s input %s: %s\n", appTagVersion, source.spec, err)
+    }
+}
+
+// startInputs opens every input in specs, the data read is sent to dc
+func startInputs(specs string, dc chan scandata) ([]*inputSource, error) {
+    var sources []*inputSource
+    for _, spec := range splitList(specs) {
+        source, err := startInput(spec, dc)
+        if err != nil {
+            closeInputs(sources)
+            return nil, err
+        }
+        sources = append(sources, source)
+    }
+    return sources, nil
+}
+
+func closeInputs(sources []*inputSource) {
+    for _, source := range sources {
+        source.close()
+    }
+}
+
+func startInput(spec string, dc chan scandata) (*inputSource, error) {
+    uri, options, err := parseDestination(spec)
+    if err != nil {
+        return nil, err
+    }
+    loop:for name := range options {
+        for _, allowed := range inputOptionNames {
+            if name == allowed {
+                continue loop
+            }
+        }
+        return nil, fmt.Errorf("unknown option '%s' for input '%s'", name, spec)
+    }
+    source := &inputSource{spec: spec, format: options.Get("format"), appname: options.Get("appname")}
+    if !validLogformat(source.format, rules.parsers) {
+        return nil, fmt.Errorf("Unsupported format '%s' for input '%s'", source.format, spec)
+    }
+    u, err := url.Parse(uri)
+    if err != nil {
+        return nil, err
+    }
+    if u.Host != "" || u.Path == "" {
+        return nil, fmt.Errorf("invalid input '%s', use fifo:///path, unix:///path or unixgram:///path", spec)
+    }
+    switch u.Scheme {
+    case "fifo":
+        if err = makeFifo(u.Path); err != nil {
+            return nil, err
+        }
+        go source.readFifo(u.Path, dc)
+    case "unix":
+        removeStaleSocket(u.Path)
+        l, err := net.Listen("unix", u.Path)
+        if err != nil {
+            return nil, err
+        }
+        os.Chmod(u.Path, 0666)
+        source.closer = l
+        go source.acceptConnections(l, dc)
+    case "unixgram":
+        removeStaleSocket(u.Path)
+        conn, err := net.ListenPacket("unixgram", u.Path)
+        if err != nil {
+            return nil, err
+        }
+        os.Chmod(u.Path, 0666)
+        source.closer = conn
+        go source.receivePackets(conn, dc)
+    default:
+        return nil, fmt.Errorf("invalid input '%s', use fifo:///path, unix:///path or unixgram:///path", spec)
+    }
+    return source, nil
+}
+
+// removeStaleSocket removes a socket left behind by a previous run
+func removeStaleSocket(path string) {
+    if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+        os.Remove(path)
+    }
+}
+
+func makeFifo(path string) error {
+    info, err := os.Stat(path)
+    if err == nil {
+        if info.Mode()&os.ModeNamedPipe == 0 {
+            return fmt.Errorf("input '%s' exists and is not a fifo", path)
+        }
+        return nil
+    }
+    if err = syscall.Mkfifo(path, 0666); err != nil {
+        return fmt.Errorf("creating fifo '%s': %s", path, err)
+    }
+    // mkfifo is subject to the umask
+    return os.Chmod(path, 0666)
+}
+
+// readFifo reads the fifo until every writer has closed it, and opens it
+// again for the next writer. Opening blocks until there is a writer.
+func (source *inputSource) readFifo(path string, dc chan scandata) {
+    for !source.isClosed() {
+        f, err := os.Open(path)
+        if err != nil {
+            source.logError(err)
+            return
+        }
+        if err = source.scan(dc, f); err != nil {
+            source.logError(err)
+        }
+        f.Close()
+    }
+}
+
+func (source *inputSource) acceptConnections(l net.Listener, dc chan scandata) {
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            if !isClosedError(err) {
+                source.logError(err)
+            }
+            return
+        }
+        if !source.track(conn) {
+            conn.Close()
+            return
+        }
+        go func() {
+            defer conn.Close()
+            defer source.untrack(conn)
+            if err := source.scan(dc, conn); err != nil && !isClosedError(err) {
+                source.logError(err)
+            }
+        }()
+    }
+}
+
+func (source *inputSource) receivePackets(conn net.PacketConn, dc chan scandata) {
+    buf := make([]byte, maxReceivedMessageSize)
+    for {
+        n, _, err := conn.ReadFrom(buf)
+        if err != nil {
+            if !isClosedError(err) {
+                source.logError(err)
+            }
+            return
+        }
+        // a datagram can have several lines, the last one doesn't need a newline
+        if err = source.scan(dc, bytes.NewReader(buf[:n])); err != nil {
+            source.logError(err)
+        }
+    }
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "io/ioutil"
+    "net"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func receiveScanData(t *testing.T, dc chan scandata) scandata {
+    select {
+    case data := <-dc:
+        return data
+    case <-time.After(5 * time.Second):
+        t.Fatal("nothing received")
+    }
+    return scandata{}
+}
+
+func TestUnixInput(t *testing.T) {
+    dir, err := ioutil.TempDir("", "pipe2log")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "input.sock")
+    dc := make(chan scandata)
+    source, err := startInput("unix://"+path+"?appname=web&format=pino", dc)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var clients []net.Conn
+    for _, line := range []string{`{"msg":"one"}`, `{"msg":"two"}`} {
+        conn, err := net.Dial("unix", path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        clients = append(clients, conn)
+        conn.Write([]byte(line + "\n"))
+        data := receiveScanData(t, dc)
+        if string(data.data) != line || data.source != source {
+            t.Errorf("got %q from %v, expected %q", data.data, data.source, line)
+        }
+    }
+
+    // closing the input closes the connections still open
+    source.close()
+    for _, conn := range clients {
+        conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+        if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+            t.Errorf("connection not closed: %v", err)
+        }
+    }
+    if _, err := net.Dial("unix", path); err == nil {
+        t.Errorf("still accepting connections")
+    }
+}
+
+func isTimeout(err error) bool {
+    netErr, ok := err.(net.Error)
+    return ok && netErr.Timeout()
+}
+
+func TestUnixgramInput(t *testing.T) {
+    dir, err := ioutil.TempDir("", "pipe2log")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "input.sock")
+    dc := make(chan scandata)
+    source, err := startInput("unixgram://"+path, dc)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer source.close()
+
+    conn, err := net.Dial("unixgram", path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    // the last line of a datagram doesn't need a newline
+    conn.Write([]byte("one\ntwo"))
+    for _, want := range []string{"one", "two"} {
+        if got := string(receiveScanData(t, dc).data); got != want {
+            t.Errorf("got %q, expected %q", got, want)
+        }
+    }
+}
+
+func TestStartInputErrors(t *testing.T) {
+    for _, spec := range []string{
+        "tcp://localhost:514",
+        "unix://host/path",
+        "fifo://",
+        "unix:///tmp/x.sock?format=unknown",
+        "unix:///tmp/x.sock?facility=local0",
+    } {
+        if source, err := startInput(spec, make(chan scandata)); err == nil {
+            source.close()
+            t.Errorf("%s: expected an error", spec)
+        }
+    }
+}
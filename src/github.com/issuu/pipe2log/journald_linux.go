@@ -0,0 +1,217 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "io/ioutil"
+    "net"
+    url "net/url"
+    "os"
+    "runtime"
+    "sort"
+    "strings"
+    "syscall"
+    "unsafe"
+)
+
+// journaldSink writes to the systemd journal with the native protocol, so
+// the parsed json fields are kept as journal fields, i.e.
+//   journald
+//   journald:///run/systemd/journal/socket?prefix=APP_
+// Field names are uppercased, nested objects are flattened with an
+// underscore. Messages too large for a datagram are passed in a memfd.
+type journaldSink struct {
+    addr *net.UnixAddr
+    prefix string
+    // not connected, messages with a file descriptor can't be sent on a
+    // connected socket
+    conn *net.UnixConn
+}
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+// the fields set by pipe2log, parsed fields with these names are prefixed
+var journaldReservedFields = map[string]bool{
+    "MESSAGE": true, "PRIORITY": true, "SYSLOG_IDENTIFIER": true, "SYSLOG_FACILITY": true,
+}
+
+// memfd_create is missing from syscall on some architectures
+var memfdCreateSyscall = map[string]uintptr{
+    "386": 356, "amd64": 319, "arm": 385, "arm64": 279, "ppc64": 360, "ppc64le": 360, "s390x": 350,
+}
+
+const (
+    mfdCloexec = 0x1
+    mfdAllowSealing = 0x2
+    fAddSeals = 1033
+    // F_SEAL_SEAL | F_SEAL_SHRINK | F_SEAL_GROW | F_SEAL_WRITE
+    fSealAll = 0x1 | 0x2 | 0x4 | 0x8
+)
+
+func openJournaldSink(uri string, options url.Values) (logSink, error) {
+    s := &journaldSink{addr: &net.UnixAddr{Name: journaldSocket, Net: "unixgram"}, prefix: journaldFieldName(options.Get("prefix"))}
+    if uri != "journald" {
+        u, err := url.Parse(uri)
+        if err != nil {
+            return nil, err
+        }
+        if u.Host != "" || u.Path == "" {
+            return nil, fmt.Errorf("invalid journald destination '%s', use journald or journald:///path/to/socket", uri)
+        }
+        s.addr.Name = u.Path
+    }
+    if _, err := os.Stat(s.addr.Name); err != nil {
+        return nil, err
+    }
+    conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+    if err != nil {
+        return nil, err
+    }
+    s.conn = conn
+    return s, nil
+}
+
+// journaldFieldName returns a valid journal field name, uppercase letters,
+// digits and underscores, not starting with an underscore or a digit.
+func journaldFieldName(name string) string {
+    name = strings.TrimLeft(strings.Map(func(r rune) rune {
+        switch {
+        case r >= 'a' && r <= 'z':
+            return r - 'a' + 'A'
+        case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+            return r
+        }
+        return '_'
+    }, name), "_")
+    if name != "" && name[0] >= '0' && name[0] <= '9' {
+        name = "F" + name
+    }
+    if len(name) > 64 {
+        name = name[:64]
+    }
+    return name
+}
+
+// addJournaldFields flattens the json fields into journal fields
+func addJournaldFields(fields map[string]string, prefix string, values map[string]interface{}) {
+    for name, value := range values {
+        if v, ok := value.(map[string]interface{}); ok {
+            addJournaldFields(fields, prefix+name+"_", v)
+            continue
+        }
+        fields[prefix+name] = fieldString(value)
+    }
+}
+
+func journaldAppendField(buf *bytes.Buffer, name, value string) {
+    buf.WriteString(name)
+    if strings.IndexByte(value, '\n') == -1 {
+        buf.WriteByte('=')
+        buf.WriteString(value)
+    } else {
+        // values with newlines are sent with their length
+        buf.WriteByte('\n')
+        size := make([]byte, 8)
+        binary.LittleEndian.PutUint64(size, uint64(len(value)))
+        buf.Write(size)
+        buf.WriteString(value)
+    }
+    buf.WriteByte('\n')
+}
+
+func (s *journaldSink) encode(m *logMessage) []byte {
+    var buf bytes.Buffer
+    facility, _ := mapFacilityString(flagSyslogFacility)
+    journaldAppendField(&buf, "MESSAGE", m.msg)
+    journaldAppendField(&buf, "PRIORITY", fmt.Sprintf("%d", m.severity&0x07))
+    journaldAppendField(&buf, "SYSLOG_IDENTIFIER", m.app())
+    journaldAppendField(&buf, "SYSLOG_FACILITY", fmt.Sprintf("%d", m.priority(facility)>>3))
+
+    values := make(map[string]string)
+    addJournaldFields(values, "", m.fields)
+    for _, t := range m.tags {
+        values[t.name] = t.value
+    }
+    fields := make(map[string]string)
+    for name, value := range values {
+        name = journaldFieldName(name)
+        if name == "" {
+            continue
+        }
+        name = s.prefix + name
+        if journaldReservedFields[name] {
+            name = "FIELD_" + name
+        }
+        fields[name] = value
+    }
+    var names []string
+    for name := range fields {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        journaldAppendField(&buf, name, fields[name])
+    }
+    return buf.Bytes()
+}
+
+func isMessageTooLarge(err error) bool {
+    if opErr, ok := err.(*net.OpError); ok {
+        err = opErr.Err
+    }
+    if sysErr, ok := err.(*os.SyscallError); ok {
+        err = sysErr.Err
+    }
+    return err == syscall.EMSGSIZE || err == syscall.ENOBUFS
+}
+
+// journaldFile returns a sealed memfd with data, or an unlinked file in
+// /dev/shm where memfd isn't available.
+func journaldFile(data []byte) (*os.File, error) {
+    if nr, ok := memfdCreateSyscall[runtime.GOARCH]; ok {
+        name := []byte("pipe2log\x00")
+        fd, _, errno := syscall.Syscall(nr, uintptr(unsafe.Pointer(&name[0])), mfdCloexec|mfdAllowSealing, 0)
+        if errno == 0 {
+            f := os.NewFile(fd, "memfd:pipe2log")
+            if _, err := f.Write(data); err != nil {
+                f.Close()
+                return nil, err
+            }
+            if _, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, fAddSeals, fSealAll); errno != 0 {
+                f.Close()
+                return nil, errno
+            }
+            return f, nil
+        }
+    }
+    f, err := ioutil.TempFile("/dev/shm", "pipe2log")
+    if err != nil {
+        return nil, err
+    }
+    os.Remove(f.Name())
+    if _, err = f.Write(data); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return f, nil
+}
+
+func (s *journaldSink) write(m *logMessage) error {
+    data := s.encode(m)
+    _, err := s.conn.WriteToUnix(data, s.addr)
+    if err == nil || !isMessageTooLarge(err) {
+        return err
+    }
+    f, err := journaldFile(data)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    _, _, err = s.conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), s.addr)
+    return err
+}
+
+func (s *journaldSink) close() error {
+    return s.conn.Close()
+}
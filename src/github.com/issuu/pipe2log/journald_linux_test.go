@@ -0,0 +1,127 @@
+package main
+
+import (
+    "bytes"
+    "io"
+    "io/ioutil"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "testing"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestJournaldFieldName(t *testing.T) {
+    tests := []struct {
+        name string
+        want string
+    }{
+        {"status", "STATUS"},
+        {"http.status-code", "HTTP_STATUS_CODE"},
+        {"_private", "PRIVATE"},
+        {"2xx", "F2XX"},
+        {"ümlaut", "MLAUT"},
+        {"___", ""},
+        {strings.Repeat("a", 70), strings.Repeat("A", 64)},
+    }
+    for _, test := range tests {
+        if got := journaldFieldName(test.name); got != test.want {
+            t.Errorf("%s: got %s, expected %s", test.name, got, test.want)
+        }
+    }
+}
+
+func TestJournaldEncode(t *testing.T) {
+    defer func(facility string) { flagSyslogFacility = facility }(flagSyslogFacility)
+    flagSyslogFacility = "local0"
+    tests := []struct {
+        prefix string
+        m *logMessage
+        want string
+    }{
+        {
+            "",
+            &logMessage{severity: syslog.LOG_WARNING, msg: "disk almost full", appname: "app"},
+            "MESSAGE=disk almost full\nPRIORITY=4\nSYSLOG_IDENTIFIER=app\nSYSLOG_FACILITY=16\n",
+        },
+        {
+            // nested fields are flattened, reserved names are prefixed
+            "",
+            &logMessage{severity: syslog.LOG_ERR, msg: "failed", appname: "app",
+                fields: map[string]interface{}{"message": "inner", "http": map[string]interface{}{"status": float64(500)}},
+                tags: []tag{{"env", "prod"}}},
+            "MESSAGE=failed\nPRIORITY=3\nSYSLOG_IDENTIFIER=app\nSYSLOG_FACILITY=16\nENV=prod\nFIELD_MESSAGE=inner\nHTTP_STATUS=500\n",
+        },
+        {
+            "app_",
+            &logMessage{severity: syslog.LOG_INFO, msg: "two\nlines", appname: "app", fields: map[string]interface{}{"user": "ann"}},
+            "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\nPRIORITY=6\nSYSLOG_IDENTIFIER=app\nSYSLOG_FACILITY=16\nAPP_USER=ann\n",
+        },
+    }
+    for _, test := range tests {
+        s := &journaldSink{prefix: journaldFieldName(test.prefix)}
+        if got := string(s.encode(test.m)); got != test.want {
+            t.Errorf("got %q, expected %q", got, test.want)
+        }
+    }
+}
+
+func TestJournaldSinkWrite(t *testing.T) {
+    dir, err := ioutil.TempDir("", "pipe2log")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "socket")
+    journal, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer journal.Close()
+    sink, err := openJournaldSink("journald://"+path, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer sink.close()
+
+    small := &logMessage{severity: syslog.LOG_INFO, msg: "small", appname: "app"}
+    // too large for a datagram, sent in a memfd
+    large := &logMessage{severity: syslog.LOG_INFO, msg: strings.Repeat("x", 4*1024*1024), appname: "app"}
+    for _, m := range []*logMessage{small, large} {
+        if err = sink.write(m); err != nil {
+            t.Fatal(err)
+        }
+        want := sink.(*journaldSink).encode(m)
+        buf := make([]byte, 64*1024)
+        oob := make([]byte, syscall.CmsgSpace(4))
+        n, oobn, _, _, err := journal.ReadMsgUnix(buf, oob)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got := buf[:n]
+        if oobn > 0 {
+            messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+            if err != nil {
+                t.Fatal(err)
+            }
+            fds, err := syscall.ParseUnixRights(&messages[0])
+            if err != nil {
+                t.Fatal(err)
+            }
+            // the offset is shared with the writer
+            f := os.NewFile(uintptr(fds[0]), "journal")
+            f.Seek(0, io.SeekStart)
+            got, err = ioutil.ReadAll(f)
+            f.Close()
+            if err != nil {
+                t.Fatal(err)
+            }
+        }
+        if !bytes.Equal(got, want) {
+            t.Errorf("got %d bytes, expected %d bytes", len(got), len(want))
+        }
+    }
+}
@@ -0,0 +1,12 @@
+// +build !linux
+
+package main
+
+import (
+    "fmt"
+    url "net/url"
+)
+
+func openJournaldSink(uri string, options url.Values) (logSink, error) {
+    return nil, fmt.Errorf("destination '%s' is only supported on linux", uri)
+}
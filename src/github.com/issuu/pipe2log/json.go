@@ -0,0 +1,49 @@
+package main
+
+import (
+    "encoding/json"
+)
+
+// jsonRecord is a message in the json lines output format
+type jsonRecord struct {
+    Timestamp string `json:"timestamp"`
+    Severity string `json:"severity"`
+    Facility string `json:"facility"`
+    Hostname string `json:"hostname"`
+    Appname string `json:"appname"`
+    Message string `json:"message"`
+    Fields map[string]interface{} `json:"fields,omitempty"`
+    Tags map[string]string `json:"tags,omitempty"`
+}
+
+func tagMap(tags []tag) map[string]string {
+    if len(tags) == 0 {
+        return nil
+    }
+    result := make(map[string]string)
+    for _, t := range tags {
+        result[t.name] = t.value
+    }
+    return result
+}
+
+// formatJSON formats m as a single line of json, without newline
+func formatJSON(m *logMessage) []byte {
+    r := jsonRecord{
+        Timestamp: m.time.Format(RFC3339Micro),
+        Severity: severityNames[m.severity&0x07],
+        Facility: m.facilityName(),
+        Hostname: m.host(),
+        Appname: m.app(),
+        Message: m.msg,
+        Fields: m.fields,
+        Tags: tagMap(m.tags),
+    }
+    _byteArray, err := json.Marshal(r)
+    if err != nil {
+        // fields we can't encode, i.e. from a badly behaving parser
+        r.Fields = nil
+        _byteArray, _ = json.Marshal(r)
+    }
+    return _byteArray
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+    "testing"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestFormatJSON(t *testing.T) {
+    tests := []struct {
+        m *logMessage
+        want string
+    }{
+        {
+            &logMessage{severity: syslog.LOG_ERR, msg: "disk full", time: testTime, hostname: "web1", appname: "app", facility: "local0"},
+            `{"timestamp":"2019-01-02T15:04:05.123456Z","severity":"err","facility":"local0","hostname":"web1","appname":"app","message":"disk full"}`,
+        },
+        {
+            &logMessage{severity: syslog.LOG_INFO, msg: "request", time: testTime, hostname: "web1", appname: "app", facility: "user",
+                fields: map[string]interface{}{"status": 200}, tags: []tag{{"env", "prod"}}},
+            `{"timestamp":"2019-01-02T15:04:05.123456Z","severity":"info","facility":"user","hostname":"web1","appname":"app","message":"request","fields":{"status":200},"tags":{"env":"prod"}}`,
+        },
+        {
+            // fields that can't be encoded are left out
+            &logMessage{severity: syslog.LOG_DEBUG, msg: "odd", time: testTime, hostname: "web1", appname: "app", facility: "user",
+                fields: map[string]interface{}{"ch": make(chan int)}},
+            `{"timestamp":"2019-01-02T15:04:05.123456Z","severity":"debug","facility":"user","hostname":"web1","appname":"app","message":"odd"}`,
+        },
+    }
+    for _, test := range tests {
+        if got := string(formatJSON(test.m)); got != test.want {
+            t.Errorf("got %s, expected %s", got, test.want)
+        }
+    }
+}
@@ -0,0 +1,252 @@
+// +build kafka
+
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "io/ioutil"
+    "log"
+    url "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/Shopify/sarama"
+)
+
+// kafkaSink produces a record per message to a kafka topic, i.e.
+//   kafka://broker1:9092/logs?broker=broker2:9092&compression=snappy
+// Records are json, like the json lines output, or syslog formatted. The
+// key is the appname or the hostname, so the messages of an application
+// stay in order on a partition. Batching is left to the producer. The
+// destination needs the sarama library, it is only built with the kafka
+// build tag.
+type kafkaSink struct {
+    topic string
+    format string
+    key string
+    producer sarama.AsyncProducer
+    // the input of the producer is unbuffered
+    queue chan *sarama.ProducerMessage
+    done sync.WaitGroup
+    dropped int64
+}
+
+// the options of the kafka destination
+var kafkaOptionNames = []string{"broker", "format", "key", "acks", "compression",
+    "tls", "tlsca", "tlscert", "tlskey", "tlsskipverify", "user", "password"}
+
+var kafkaAcks = map[string]sarama.RequiredAcks{
+    "none": sarama.NoResponse,
+    "leader": sarama.WaitForLocal,
+    "all": sarama.WaitForAll,
+}
+
+var kafkaCompression = map[string]sarama.CompressionCodec{
+    "none": sarama.CompressionNone,
+    "gzip": sarama.CompressionGZIP,
+    "snappy": sarama.CompressionSnappy,
+    "lz4": sarama.CompressionLZ4,
+}
+
+// loadTLSConfig returns the client tls config for the tls options
+func loadTLSConfig(spec string, options url.Values) (*tls.Config, error) {
+    config := &tls.Config{}
+    if ca := options.Get("tlsca"); ca != "" {
+        pem, err := ioutil.ReadFile(ca)
+        if err != nil {
+            return nil, err
+        }
+        config.RootCAs = x509.NewCertPool()
+        if !config.RootCAs.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("no certificates in tlsca '%s' for destination '%s'", ca, spec)
+        }
+    }
+    if cert := options.Get("tlscert"); cert != "" {
+        pair, err := tls.LoadX509KeyPair(cert, options.Get("tlskey"))
+        if err != nil {
+            return nil, err
+        }
+        config.Certificates = []tls.Certificate{pair}
+    }
+    if v := options.Get("tlsskipverify"); v != "" {
+        skip, err := strconv.ParseBool(v)
+        if err != nil {
+            return nil, fmt.Errorf("invalid tlsskipverify '%s' for destination '%s'", v, spec)
+        }
+        config.InsecureSkipVerify = skip
+    }
+    return config, nil
+}
+
+func openKafkaSink(spec, uri string, options url.Values) (logSink, error) {
+    if err := checkOptions(spec, options, append(kafkaOptionNames, batchOptionNames...)...); err != nil {
+        return nil, err
+    }
+    u, err := url.Parse(uri)
+    if err != nil {
+        return nil, err
+    }
+    s := &kafkaSink{topic: strings.Trim(u.Path, "/"), format: options.Get("format"), key: options.Get("key")}
+    if u.Host == "" || s.topic == "" {
+        return nil, fmt.Errorf("invalid kafka destination '%s', use kafka://broker:9092/topic", spec)
+    }
+    brokers := []string{u.Host}
+    brokers = append(brokers, options["broker"]...)
+    for i, broker := range brokers {
+        if strings.Index(broker, ":") == -1 {
+            brokers[i] += ":9092"
+        }
+    }
+    switch s.format {
+    case "":
+        s.format = "json"
+    case "json", "text", "rfc3164", "rfc5424":
+    default:
+        return nil, fmt.Errorf("Unsupported format '%s' for destination '%s', json, text, rfc3164 and rfc5424 are supported.", s.format, spec)
+    }
+    switch s.key {
+    case "":
+        s.key = "appname"
+    case "appname", "hostname", "none":
+    default:
+        return nil, fmt.Errorf("Unsupported key '%s' for destination '%s', appname, hostname and none are supported.", s.key, spec)
+    }
+
+    config := sarama.NewConfig()
+    config.ClientID = appTag
+    config.Version = sarama.V0_10_0_0
+    config.Producer.Return.Successes = false
+    config.Producer.Return.Errors = true
+    config.Producer.RequiredAcks = sarama.WaitForLocal
+    if v := options.Get("acks"); v != "" {
+        acks, ok := kafkaAcks[v]
+        if !ok {
+            return nil, fmt.Errorf("Unsupported acks '%s' for destination '%s', none, leader and all are supported.", v, spec)
+        }
+        config.Producer.RequiredAcks = acks
+    }
+    if v := options.Get("compression"); v != "" {
+        compression, ok := kafkaCompression[v]
+        if !ok {
+            return nil, fmt.Errorf("Unsupported compression '%s' for destination '%s', none, gzip, snappy and lz4 are supported.", v, spec)
+        }
+        config.Producer.Compression = compression
+    }
+    batchOptions, err := parseBatchOptions(spec, options)
+    if err != nil {
+        return nil, err
+    }
+    config.Producer.Flush.Messages = batchOptions.maxCount
+    config.Producer.Flush.Bytes = batchOptions.maxBytes
+    config.Producer.Flush.Frequency = batchOptions.interval
+    config.Producer.Retry.Max = batchOptions.retries
+    config.ChannelBufferSize = batchOptions.queueSize
+    if v := options.Get("tls"); v != "" {
+        if config.Net.TLS.Enable, err = strconv.ParseBool(v); err != nil {
+            return nil, fmt.Errorf("invalid tls '%s' for destination '%s'", v, spec)
+        }
+    }
+    if config.Net.TLS.Enable {
+        if config.Net.TLS.Config, err = loadTLSConfig(spec, options); err != nil {
+            return nil, err
+        }
+    }
+    if user := options.Get("user"); user != "" {
+        // SASL/PLAIN, use it with tls
+        config.Net.SASL.Enable = true
+        config.Net.SASL.Handshake = true
+        config.Net.SASL.User = user
+        config.Net.SASL.Password = options.Get("password")
+    }
+    if err = config.Validate(); err != nil {
+        return nil, fmt.Errorf("invalid options for destination '%s': %s", spec, err)
+    }
+
+    s.producer, err = sarama.NewAsyncProducer(brokers, config)
+    if err != nil {
+        return nil, err
+    }
+    s.start(batchOptions.queueSize)
+    return s, nil
+}
+
+func (s *kafkaSink) start(queueSize int) {
+    s.queue = make(chan *sarama.ProducerMessage, queueSize)
+    s.done.Add(2)
+    go s.produce()
+    go s.logErrors()
+}
+
+// produce passes the queued records to the producer, which is closed
+// when the queue is
+func (s *kafkaSink) produce() {
+    defer s.done.Done()
+    for record := range s.queue {
+        s.producer.Input() <- record
+    }
+    s.producer.AsyncClose()
+}
+
+// logErrors logs the records that could not be produced, and the number
+// of records dropped because the producer couldn't keep up.
+func (s *kafkaSink) logErrors() {
+    defer s.done.Done()
+    ticker := time.NewTicker(10 * time.Second)
+    defer ticker.Stop()
+    errors := s.producer.Errors()
+    for errors != nil {
+        select {
+        case err, ok := <- errors:
+            if !ok {
+                errors = nil
+                break
+            }
+            log.Printf("%s kafka topic %s: %s\n", appTagVersion, s.topic, err.Err)
+        case <- ticker.C:
+        }
+        if dropped := atomic.SwapInt64(&s.dropped, 0); dropped > 0 {
+            log.Printf("%s kafka topic %s queue full, dropped %d messages\n", appTagVersion, s.topic, dropped)
+        }
+    }
+}
+
+func (s *kafkaSink) encode(m *logMessage) []byte {
+    facility, _ := mapFacilityString(flagSyslogFacility)
+    switch s.format {
+    case "text":
+        return []byte(m.time.Format(RFC3339Micro) + " " + consoleSeverity[m.severity&0x07] + " " + m.text())
+    case "rfc3164":
+        return []byte(formatRFC3164(m.severity|facility, m, m.text(), false))
+    case "rfc5424":
+        return []byte(formatRFC5424(m.severity|facility, m, m.text()))
+    }
+    return formatJSON(m)
+}
+
+func (s *kafkaSink) write(m *logMessage) error {
+    record := &sarama.ProducerMessage{Topic: s.topic, Value: sarama.ByteEncoder(s.encode(m)), Timestamp: m.time}
+    switch s.key {
+    case "appname":
+        record.Key = sarama.StringEncoder(m.app())
+    case "hostname":
+        record.Key = sarama.StringEncoder(m.host())
+    }
+    // never block the input
+    select {
+    case s.queue <- record:
+    default:
+        atomic.AddInt64(&s.dropped, 1)
+    }
+    return nil
+}
+
+func (s *kafkaSink) close() error {
+    close(s.queue)
+    s.done.Wait()
+    return nil
+}
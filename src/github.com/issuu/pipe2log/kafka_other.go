@@ -0,0 +1,12 @@
+// +build !kafka
+
+package main
+
+import (
+    "fmt"
+    url "net/url"
+)
+
+func openKafkaSink(spec, uri string, options url.Values) (logSink, error) {
+    return nil, fmt.Errorf("destination '%s' needs pipe2log built with the kafka tag", spec)
+}
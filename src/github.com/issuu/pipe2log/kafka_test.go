@@ -0,0 +1,83 @@
+// +build kafka
+
+package main
+
+import (
+    "testing"
+
+    "github.com/Shopify/sarama"
+    "github.com/Shopify/sarama/mocks"
+    syslog "github.com/issuu/srslog"
+)
+
+func TestKafkaSinkRecord(t *testing.T) {
+    m := &logMessage{severity: syslog.LOG_ERR, msg: "failed", time: testTime, hostname: "web1", appname: "app", facility: "user"}
+    tests := []struct {
+        format string
+        key string
+        wantKey string
+        wantValue string
+    }{
+        {"json", "appname", "app", `{"timestamp":"2019-01-02T15:04:05.123456Z","severity":"err","facility":"user","hostname":"web1","appname":"app","message":"failed"}`},
+        {"text", "hostname", "web1", "2019-01-02T15:04:05.123456Z ERROR failed"},
+        {"text", "none", "", "2019-01-02T15:04:05.123456Z ERROR failed"},
+    }
+    for _, test := range tests {
+        config := sarama.NewConfig()
+        config.Producer.Return.Successes = true
+        producer := mocks.NewAsyncProducer(t, config)
+        producer.ExpectInputAndSucceed()
+        s := &kafkaSink{topic: "logs", format: test.format, key: test.key, producer: producer}
+        s.start(10)
+        s.write(m)
+        record := <-producer.Successes()
+        s.close()
+
+        var key string
+        if record.Key != nil {
+            encoded, _ := record.Key.Encode()
+            key = string(encoded)
+        }
+        value, _ := record.Value.Encode()
+        if record.Topic != "logs" || key != test.wantKey || string(value) != test.wantValue || !record.Timestamp.Equal(testTime) {
+            t.Errorf("%s %s: got %s key %q value %s at %s", test.format, test.key, record.Topic, key, value, record.Timestamp)
+        }
+    }
+}
+
+func TestKafkaSinkProduces(t *testing.T) {
+    broker := sarama.NewMockBroker(t, 1)
+    defer broker.Close()
+    broker.SetHandlerByMap(map[string]sarama.MockResponse{
+        "MetadataRequest": sarama.NewMockMetadataResponse(t).
+            SetBroker(broker.Addr(), broker.BrokerID()).
+            SetLeader("logs", 0, broker.BrokerID()),
+        "ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(2),
+    })
+    sink := openTestSink(t, openKafkaSink, "kafka://"+broker.Addr()+"/logs?acks=all")
+    sink.write(&logMessage{severity: syslog.LOG_INFO, msg: "one", time: testTime, hostname: "web1", appname: "app"})
+    sink.close()
+
+    produced := 0
+    for _, rr := range broker.History() {
+        if request, ok := rr.Request.(*sarama.ProduceRequest); ok {
+            produced++
+            if request.RequiredAcks != sarama.WaitForAll {
+                t.Errorf("got acks %d, expected all", request.RequiredAcks)
+            }
+        }
+    }
+    if produced != 1 {
+        t.Errorf("got %d produce requests, expected 1", produced)
+    }
+}
+
+func TestOpenKafkaSinkOptions(t *testing.T) {
+    for _, query := range []string{"format=xml", "key=topic", "acks=some", "compression=zstd", "tls=maybe", "queue=0"} {
+        spec := "kafka://localhost:1/logs?" + query
+        uri, options, _ := parseDestination(spec)
+        if _, err := openKafkaSink(spec, uri, options); err == nil {
+            t.Errorf("%s: expected an error", query)
+        }
+    }
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net"
+    url "net/url"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+// With -listen pipe2log is a syslog server instead of reading its input
+// from a pipe, i.e.
+//   -listen udp://:514,tcp://:514,unix:///dev/log
+// Received rfc3164 and rfc5424 messages keep their facility, time,
+// hostname, appname and process id, and are sent through the filters to the
+// destinations like messages read from a pipe. Over tcp messages are
+// separated by a newline or prefixed with their length (rfc6587).
+
+var flagListen string
+
+// larger messages are cut off
+const maxReceivedMessageSize = 64 * 1024
+
+// received messages waiting for the main loop
+const receivedQueueSize = 10000
+
+// startListeners listens on every address in addresses, received messages
+// are sent to received.
+func startListeners(addresses string, received chan<- *logMessage) ([]io.Closer, error) {
+    var listeners []io.Closer
+    for _, address := range splitList(addresses) {
+        l, err := startListener(address, received)
+        if err != nil {
+            closeListeners(listeners)
+            return nil, err
+        }
+        listeners = append(listeners, l)
+    }
+    return listeners, nil
+}
+
+func closeListeners(listeners []io.Closer) {
+    for _, l := range listeners {
+        l.Close()
+    }
+}
+
+func startListener(address string, received chan<- *logMessage) (io.Closer, error) {
+    u, err := url.Parse(address)
+    if err != nil {
+        return nil, err
+    }
+    switch u.Scheme {
+    case "udp":
+        conn, err := net.ListenPacket("udp", u.Host)
+        if err != nil {
+            return nil, err
+        }
+        go receivePackets(conn, received)
+        return conn, nil
+    case "tcp":
+        l, err := net.Listen("tcp", u.Host)
+        if err != nil {
+            return nil, err
+        }
+        go acceptConnections(l, received)
+        return l, nil
+    case "unix":
+        if u.Path == "" {
+            break
+        }
+        removeStaleSocket(u.Path)
+        conn, err := net.ListenPacket("unixgram", u.Path)
+        if err != nil {
+            return nil, err
+        }
+        // anyone can log, like with /dev/log
+        os.Chmod(u.Path, 0666)
+        go receivePackets(conn, received)
+        return conn, nil
+    }
+    return nil, fmt.Errorf("invalid listen address '%s', use udp://[host]:port, tcp://[host]:port or unix:///path", address)
+}
+
+// senderHost is the hostname for messages without one
+func senderHost(addr net.Addr) string {
+    if addr == nil {
+        return ""
+    }
+    host, _, err := net.SplitHostPort(addr.String())
+    if err != nil {
+        return ""
+    }
+    return host
+}
+
+func receivePackets(conn net.PacketConn, received chan<- *logMessage) {
+    buf := make([]byte, maxReceivedMessageSize)
+    for {
+        n, addr, err := conn.ReadFrom(buf)
+        if err != nil {
+            if !isClosedError(err) {
+                log.Printf("%s syslog listener %s: %s\n", appTagVersion, conn.LocalAddr(), err)
+            }
+            return
+        }
+        data := bytes.TrimRight(buf[:n], "\r\n\x00")
+        if len(data) > 0 {
+            received <- parseSyslogMessage(string(data), senderHost(addr))
+        }
+    }
+}
+
+func isClosedError(err error) bool {
+    return strings.Contains(err.Error(), "use of closed network connection")
+}
+
+func acceptConnections(l net.Listener, received chan<- *logMessage) {
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            if !isClosedError(err) {
+                log.Printf("%s syslog listener %s: %s\n", appTagVersion, l.Addr(), err)
+            }
+            return
+        }
+        go receiveStream(conn, received)
+    }
+}
+
+// receiveStream reads messages separated by newlines, or prefixed with
+// their length, the framing is detected for every message.
+func receiveStream(conn net.Conn, received chan<- *logMessage) {
+    defer conn.Close()
+    host := senderHost(conn.RemoteAddr())
+    r := bufio.NewReaderSize(conn, maxReceivedMessageSize)
+    for {
+        first, err := r.Peek(1)
+        if err != nil {
+            return
+        }
+        var data []byte
+        if first[0] >= '1' && first[0] <= '9' {
+            // octet counting, MSG-LEN SP SYSLOG-MSG
+            length, err := r.ReadString(' ')
+            if err != nil {
+                return
+            }
+            n, err := strconv.Atoi(strings.TrimSpace(length))
+            if err != nil || n <= 0 {
+                log.Printf("%s syslog listener: invalid message length '%s' from %s\n", appTagVersion, length, host)
+                return
+            }
+            // the length comes from the sender, the rest of a too long
+            // message is skipped without reading it into memory
+            size := n
+            if size > maxReceivedMessageSize {
+                size = maxReceivedMessageSize
+            }
+            data = make([]byte, size)
+            if _, err = io.ReadFull(r, data); err != nil {
+                return
+            }
+            if n > size {
+                if _, err = io.CopyN(ioutil.Discard, r, int64(n-size)); err != nil {
+                    return
+                }
+            }
+        } else {
+            line, err := r.ReadSlice('\n')
+            if err == bufio.ErrBufferFull {
+                // skip the rest of a too long line
+                data = append([]byte{}, line...)
+                for err == bufio.ErrBufferFull {
+                    _, err = r.ReadSlice('\n')
+                }
+            } else {
+                data = line
+            }
+            if err != nil && len(data) == 0 {
+                return
+            }
+        }
+        data = bytes.TrimRight(data, "\r\n\x00")
+        if len(data) > 0 {
+            received <- parseSyslogMessage(string(data), host)
+        }
+    }
+}
+
+var syslogPri_re = regexp.MustCompile(`^<([0-9]{1,3})>`)
+// TAG[PID]: at the start of a rfc3164 message
+var syslogTag_re = regexp.MustCompile(`^([^\s\[\]:]+)(?:\[([^\]]*)\])?:(?: |$)`)
+
+// parseSyslogMessage parses a rfc5424 or rfc3164 message, host is used
+// if the message has no hostname.
+func parseSyslogMessage(data, host string) *logMessage {
+    // without a priority it is user.notice
+    m := &logMessage{severity: syslog.LOG_NOTICE, facility: "user"}
+    if rs := syslogPri_re.FindStringSubmatch(data); rs != nil {
+        pri, _ := strconv.Atoi(rs[1])
+        m.severity = syslog.Priority(pri) & 0x07
+        m.facility = ""
+        if pri>>3 < len(facilityNames) {
+            m.facility = facilityNames[pri>>3]
+        }
+        data = data[len(rs[0]):]
+    }
+    if strings.HasPrefix(data, "1 ") {
+        parseRFC5424(m, data[2:])
+    } else {
+        parseRFC3164(m, data)
+    }
+    if m.time.IsZero() {
+        m.time = time.Now()
+    }
+    if m.hostname == "" {
+        m.hostname = host
+    }
+    return m
+}
+
+func nilValue(s string) string {
+    if s == "-" {
+        return ""
+    }
+    return s
+}
+
+// parseRFC5424 parses the message after the version,
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseRFC5424(m *logMessage, data string) {
+    header := strings.SplitN(data, " ", 6)
+    if len(header) < 6 {
+        m.msg = data
+        return
+    }
+    if t, err := time.Parse(time.RFC3339Nano, header[0]); err == nil {
+        m.time = t
+    }
+    m.hostname = nilValue(header[1])
+    m.appname = nilValue(header[2])
+    m.procid = nilValue(header[3])
+    m.msgid = nilValue(header[4])
+    rest := header[5]
+    if strings.HasPrefix(rest, "-") {
+        rest = rest[1:]
+    } else {
+        end := structuredDataEnd(rest)
+        m.structuredData = rest[:end]
+        rest = rest[end:]
+    }
+    m.msg = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\xef\xbb\xbf")
+}
+
+// structuredDataEnd returns the end of the sd elements at the start of
+// data, ] and " are escaped with a backslash in parameter values.
+func structuredDataEnd(data string) int {
+    inElement := false
+    inValue := false
+    for i := 0; i < len(data); i++ {
+        switch c := data[i]; {
+        case inValue && c == '\\':
+            i++
+        case inValue && c == '"':
+            inValue = false
+        case inValue:
+        case inElement && c == '"':
+            inValue = true
+        case inElement && c == ']':
+            inElement = false
+        case !inElement && c == '[':
+            inElement = true
+        case !inElement:
+            return i
+        }
+    }
+    return len(data)
+}
+
+// parseRFC3164 parses TIMESTAMP HOSTNAME TAG[PID]: MSG, the hostname is
+// missing in messages from the local /dev/log.
+func parseRFC3164(m *logMessage, data string) {
+    data = parseRFC3164Timestamp(m, data)
+    // without a timestamp it is only the message
+    if m.time.IsZero() {
+        m.msg = data
+        return
+    }
+    if idx := strings.IndexByte(data, ' '); idx > 0 && !syslogTag_re.MatchString(data) {
+        m.hostname = data[:idx]
+        data = data[idx+1:]
+    }
+    if rs := syslogTag_re.FindStringSubmatch(data); rs != nil {
+        m.appname = rs[1]
+        m.procid = rs[2]
+        data = data[len(rs[0]):]
+    }
+    m.msg = data
+}
+
+// parseRFC3164Timestamp parses a 'Jan _2 15:04:05' or rfc3339 timestamp
+// and returns the rest of the message.
+func parseRFC3164Timestamp(m *logMessage, data string) string {
+    if idx := strings.IndexByte(data, ' '); idx > 0 {
+        if t, err := time.Parse(time.RFC3339Nano, data[:idx]); err == nil {
+            m.time = t
+            return data[idx+1:]
+        }
+    }
+    if len(data) < len(time.Stamp) {
+        return data
+    }
+    t, err := time.ParseInLocation(time.Stamp, data[:len(time.Stamp)], time.Local)
+    if err != nil {
+        return data
+    }
+    // there is no year
+    m.time = withCurrentYear(t)
+    return strings.TrimPrefix(data[len(time.Stamp):], " ")
+}
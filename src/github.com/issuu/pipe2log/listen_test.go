@@ -0,0 +1,122 @@
+package main
+
+import (
+    "net"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestParseSyslogMessage(t *testing.T) {
+    year := time.Now().Year()
+    tests := []struct {
+        data string
+        expected logMessage
+    }{
+        {"<34>1 2019-01-02T15:04:05.123456Z web1 app 42 ID47 - failed",
+            logMessage{severity: syslog.LOG_CRIT, facility: "auth", time: testTime, hostname: "web1", appname: "app", procid: "42", msgid: "ID47", msg: "failed"}},
+        {`<165>1 2019-01-02T15:04:05.123456Z - - - - [meta@1 a="x\"]y" b="2"][origin ip="10.0.0.1"] ` + "\xef\xbb\xbfstarted",
+            logMessage{severity: syslog.LOG_NOTICE, facility: "local4", time: testTime, hostname: "sender",
+                structuredData: `[meta@1 a="x\"]y" b="2"][origin ip="10.0.0.1"]`, msg: "started"}},
+        {"<14>1 2019-01-02T15:04:05.123456Z web1 app - - [meta@1 a=\"1\"]",
+            logMessage{severity: syslog.LOG_INFO, facility: "user", time: testTime, hostname: "web1", appname: "app", structuredData: `[meta@1 a="1"]`}},
+        {"<38>Jan  2 15:04:05 web1 sshd[123]: Accepted publickey",
+            logMessage{severity: syslog.LOG_INFO, facility: "auth", time: time.Date(year, 1, 2, 15, 4, 5, 0, time.Local), hostname: "web1", appname: "sshd", procid: "123", msg: "Accepted publickey"}},
+        // from the local /dev/log there is no hostname
+        {"<30>Jan  2 15:04:05 cron: started",
+            logMessage{severity: syslog.LOG_INFO, facility: "daemon", time: time.Date(year, 1, 2, 15, 4, 5, 0, time.Local), hostname: "sender", appname: "cron", msg: "started"}},
+        {"<11>2019-01-02T15:04:05.123456Z web1 app: failed",
+            logMessage{severity: syslog.LOG_ERR, facility: "user", time: testTime, hostname: "web1", appname: "app", msg: "failed"}},
+        {"just a message",
+            logMessage{severity: syslog.LOG_NOTICE, facility: "user", hostname: "sender", msg: "just a message"}},
+    }
+    for _, test := range tests {
+        m := parseSyslogMessage(test.data, "sender")
+        e := test.expected
+        if e.time.IsZero() {
+            if time.Since(m.time) > time.Minute {
+                t.Errorf("%s: got time %s, expected now", test.data, m.time)
+            }
+            e.time = m.time
+        }
+        if m.severity != e.severity || m.facility != e.facility || !m.time.Equal(e.time) || m.hostname != e.hostname ||
+            m.appname != e.appname || m.procid != e.procid || m.msgid != e.msgid || m.structuredData != e.structuredData || m.msg != e.msg {
+            t.Errorf("%s: got %+v, expected %+v", test.data, *m, e)
+        }
+    }
+}
+
+func TestReceivedFacility(t *testing.T) {
+    m := parseSyslogMessage("<35>1 2019-01-02T15:04:05.123456Z web1 sshd 42 - - failed", "")
+    // the facility of the destination is only used without one received
+    if got := formatRFC5424(m.severity|syslog.LOG_LOCAL4, m, m.msg); !strings.HasPrefix(got, "<35>1 ") {
+        t.Errorf("got %s, expected the auth facility", got)
+    }
+    if got := formatRFC3164(syslog.LOG_ERR|syslog.LOG_LOCAL4, &logMessage{time: testTime}, "failed", true); !strings.HasPrefix(got, "<163>") {
+        t.Errorf("got %s, expected the local4 facility", got)
+    }
+}
+
+func TestReceiveStream(t *testing.T) {
+    client, server := net.Pipe()
+    received := make(chan *logMessage, 10)
+    done := make(chan bool)
+    go func() {
+        receiveStream(server, received)
+        close(done)
+    }()
+
+    long := strings.Repeat("x", maxReceivedMessageSize+100)
+    framed := "<11>1 2019-01-02T15:04:05Z web1 app - - - " + long
+    go func() {
+        client.Write([]byte("<14>one\n"))
+        client.Write([]byte("13 <14>two\nthree"))
+        client.Write([]byte(strings.Repeat("y", maxReceivedMessageSize+10) + "\n"))
+        client.Write([]byte(strconv.Itoa(len(framed)) + " " + framed))
+        client.Write([]byte("<14>after\n"))
+        client.Close()
+    }()
+    <-done
+    close(received)
+
+    var msgs []string
+    for m := range received {
+        msgs = append(msgs, m.msg)
+    }
+    // a frame can contain newlines, a too long message is cut off
+    expected := []string{"one", "two\nthree", strings.Repeat("y", maxReceivedMessageSize), framed[len(framed)-len(long):maxReceivedMessageSize], "after"}
+    if len(msgs) != len(expected) {
+        t.Fatalf("got %d messages, expected %d", len(msgs), len(expected))
+    }
+    for i := range expected {
+        if msgs[i] != expected[i] {
+            t.Errorf("message %d: got %d bytes %.20q, expected %d bytes %.20q", i, len(msgs[i]), msgs[i], len(expected[i]), expected[i])
+        }
+    }
+}
+
+func TestReceiveStreamHugeLength(t *testing.T) {
+    client, server := net.Pipe()
+    received := make(chan *logMessage, 1)
+    done := make(chan bool)
+    go func() {
+        receiveStream(server, received)
+        close(done)
+    }()
+    // the length is not allocated, the connection ends before the frame
+    go func() {
+        client.Write([]byte("999999999999 <14>short"))
+        client.Close()
+    }()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("still receiving")
+    }
+    if len(received) != 0 {
+        t.Errorf("got %q for an incomplete frame", (<-received).msg)
+    }
+}
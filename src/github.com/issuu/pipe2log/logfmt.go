@@ -0,0 +1,136 @@
+package main
+
+import (
+    "strconv"
+    "strings"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+// logfmt lines are key=value pairs, values with spaces are quoted, i.e.
+//   time=2019-01-02T15:04:05Z level=warn msg="disk almost full" free=2%
+// The msg, level and time keys make the message, the other keys are
+// added as fields. Lines without a msg, level or time key that aren't
+// only key=value pairs, like "ERROR connecting to db=foo", are scanned for
+// a severity like the default logformat.
+
+// the keys holding the message, the level and the time
+var logfmtMessageKeys = []string{"msg", "message"}
+var logfmtLevelKeys = []string{"level", "lvl", "severity"}
+var logfmtTimeKeys = []string{"time", "ts", "timestamp"}
+
+// levelSeverity maps a level name used by logging libraries to a severity
+func levelSeverity(level string) (syslog.Priority, bool) {
+    switch strings.ToLower(level) {
+    case "trace":
+        return syslog.LOG_DEBUG, true
+    case "fatal", "panic":
+        return syslog.LOG_CRIT, true
+    }
+    severity, err := mapSeverityString(level)
+    return severity, err == nil
+}
+
+// splitLogfmt returns the key=value pairs of a logfmt line, a key
+// without a value is true and counted in bare.
+func splitLogfmt(line string) (pairs map[string]string, bare int) {
+    pairs = make(map[string]string)
+    i := 0
+    for i < len(line) {
+        for i < len(line) && line[i] == ' ' {
+            i++
+        }
+        start := i
+        for i < len(line) && line[i] != '=' && line[i] != ' ' {
+            i++
+        }
+        key := line[start:i]
+        if i >= len(line) || line[i] == ' ' {
+            if key != "" {
+                pairs[key] = "true"
+                bare++
+            }
+            continue
+        }
+        // skip the =
+        i++
+        if i < len(line) && line[i] == '"' {
+            start = i
+            for i++; i < len(line) && line[i] != '"'; i++ {
+                if line[i] == '\\' {
+                    i++
+                }
+            }
+            if i < len(line) {
+                i++
+            }
+            value, err := strconv.Unquote(line[start:i])
+            if err != nil {
+                value = strings.Trim(line[start:i], `"`)
+            }
+            pairs[key] = value
+        } else {
+            start = i
+            for i < len(line) && line[i] != ' ' {
+                i++
+            }
+            pairs[key] = line[start:i]
+        }
+    }
+    return pairs, bare
+}
+
+// hasLogfmtKey is true if pairs has one of keys
+func hasLogfmtKey(pairs map[string]string, keys []string) bool {
+    for _, key := range keys {
+        if _, ok := pairs[key]; ok {
+            return true
+        }
+    }
+    return false
+}
+
+// takeLogfmtKey removes and returns the first of keys found in pairs
+func takeLogfmtKey(pairs map[string]string, keys []string) (string, bool) {
+    for _, key := range keys {
+        if value, ok := pairs[key]; ok {
+            delete(pairs, key)
+            return value, true
+        }
+    }
+    return "", false
+}
+
+// parseLogfmt parses a logfmt line, nil if it doesn't look like one
+func parseLogfmt(data []byte) (*logMessage, bool) {
+    line := string(data)
+    if strings.Index(line, "=") == -1 {
+        return nil, false
+    }
+    pairs, bare := splitLogfmt(line)
+    if bare > 0 && !hasLogfmtKey(pairs, logfmtMessageKeys) && !hasLogfmtKey(pairs, logfmtLevelKeys) && !hasLogfmtKey(pairs, logfmtTimeKeys) {
+        return nil, false
+    }
+    lm := &logMessage{severity: syslog.LOG_INFO}
+    found := false
+    if level, ok := takeLogfmtKey(pairs, logfmtLevelKeys); ok {
+        lm.severity, found = levelSeverity(level)
+        if !found {
+            lm.severity = syslog.LOG_INFO
+        }
+    }
+    if ts, ok := takeLogfmtKey(pairs, logfmtTimeKeys); ok {
+        if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+            lm.time = t
+        }
+    }
+    lm.msg, _ = takeLogfmtKey(pairs, logfmtMessageKeys)
+    if len(pairs) > 0 {
+        lm.fields = make(map[string]interface{})
+        for key, value := range pairs {
+            lm.fields[key] = value
+        }
+    }
+    return lm, found
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "testing"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestParseLogfmt(t *testing.T) {
+    tests := []struct {
+        line string
+        severity syslog.Priority
+        found bool
+        msg string
+        fields map[string]interface{}
+    }{
+        {`time=2019-01-02T15:04:05.123456Z level=warn msg="disk \"data\" almost full" free=2%`, syslog.LOG_WARNING, true,
+            `disk "data" almost full`, map[string]interface{}{"free": "2%"}},
+        {`lvl=fatal message=crashed`, syslog.LOG_CRIT, true, "crashed", nil},
+        {`level=verbose msg=hello`, syslog.LOG_INFO, false, "hello", nil},
+        {`msg=started debug`, syslog.LOG_INFO, false, "started", map[string]interface{}{"debug": "true"}},
+        {`user=42 path=/login status=200`, syslog.LOG_INFO, false, "", map[string]interface{}{"user": "42", "path": "/login", "status": "200"}},
+    }
+    for _, test := range tests {
+        lm, found := parseLogfmt([]byte(test.line))
+        if lm == nil {
+            t.Errorf("%s: not parsed", test.line)
+            continue
+        }
+        if lm.severity != test.severity || found != test.found || lm.msg != test.msg || len(lm.fields) != len(test.fields) {
+            t.Errorf("%s: got %d %v %q %v", test.line, lm.severity, found, lm.msg, lm.fields)
+            continue
+        }
+        for key, value := range test.fields {
+            if lm.fields[key] != value {
+                t.Errorf("%s: got field %s %v, expected %v", test.line, key, lm.fields[key], value)
+            }
+        }
+    }
+    if lm, _ := parseLogfmt([]byte("time=2019-01-02T15:04:05.123456Z msg=x")); !lm.time.Equal(testTime) {
+        t.Errorf("got time %s", lm.time)
+    }
+}
+
+func TestParseLogfmtPlainLines(t *testing.T) {
+    for _, line := range []string{"ERROR connecting to db=foo", "listening on :8080", "a = b"} {
+        if lm, _ := parseLogfmt([]byte(line)); lm != nil {
+            t.Errorf("%s: got %q %v, expected no logfmt", line, lm.msg, lm.fields)
+        }
+    }
+}
+
+func TestParseLogLineLogfmtFallback(t *testing.T) {
+    _, restore := useTestPipeline(t)
+    defer restore()
+    lm, found := parseLogLine("logfmt", []byte("ERROR connecting to db=foo"))
+    if lm == nil || !found || lm.severity != syslog.LOG_ERR || lm.msg != "connecting to db=foo" || len(lm.fields) != 0 {
+        t.Errorf("got %+v", lm)
+    }
+    lm, found = parseLogLine("logfmt", []byte("msg=started"))
+    if lm == nil || found || lm.severity != syslog.LOG_INFO || lm.msg != "started" {
+        t.Errorf("got %+v", lm)
+    }
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    url "net/url"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// otlpSink exports batches of messages as OpenTelemetry logs, with the
+// json encoding of OTLP/HTTP, i.e.
+//   otlp+http://collector:4318
+// posts to http://collector:4318/v1/logs. The hostname, appname and tags
+// are resource attributes, the parsed json fields are log attributes.
+type otlpSink struct {
+    poster *httpPoster
+    batcher *batcher
+}
+
+const otlpScheme = "otlp+"
+
+// OpenTelemetry severity numbers and texts, by syslog severity
+var otlpSeverityNumbers = [...]int{21, 19, 18, 17, 13, 10, 9, 5}
+var otlpSeverityTexts = [...]string{"FATAL", "ERROR3", "ERROR2", "ERROR", "WARN", "INFO2", "INFO", "DEBUG"}
+
+func openOTLPSink(spec, uri string, options url.Values) (logSink, error) {
+    uri = strings.TrimPrefix(uri, otlpScheme)
+    u, err := url.Parse(uri)
+    if err != nil {
+        return nil, err
+    }
+    if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+        return nil, fmt.Errorf("invalid otlp destination '%s', use otlp+http(s)://host:4318", spec)
+    }
+    if u.Path == "" || u.Path == "/" {
+        u.Path = "/v1/logs"
+    }
+    batchOptions, err := parseBatchOptions(spec, options)
+    if err != nil {
+        return nil, err
+    }
+    s := &otlpSink{}
+    s.poster, err = newHTTPPoster(spec, u.String(), options)
+    if err != nil {
+        return nil, err
+    }
+    s.batcher = newBatcher(uri, batchOptions, s.send)
+    return s, nil
+}
+
+type otlpKeyValue struct {
+    Key string `json:"key"`
+    Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+    StringValue *string `json:"stringValue,omitempty"`
+    BoolValue *bool `json:"boolValue,omitempty"`
+    // int64 is a string in the json encoding
+    IntValue string `json:"intValue,omitempty"`
+    DoubleValue *float64 `json:"doubleValue,omitempty"`
+    ArrayValue *otlpArrayValue `json:"arrayValue,omitempty"`
+    KvlistValue *otlpKvlistValue `json:"kvlistValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+    Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKvlistValue struct {
+    Values []otlpKeyValue `json:"values"`
+}
+
+type otlpLogRecord struct {
+    TimeUnixNano string `json:"timeUnixNano"`
+    ObservedTimeUnixNano string `json:"observedTimeUnixNano"`
+    SeverityNumber int `json:"severityNumber"`
+    SeverityText string `json:"severityText"`
+    Body otlpAnyValue `json:"body"`
+    Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScope struct {
+    Name string `json:"name"`
+    Version string `json:"version"`
+}
+
+type otlpScopeLogs struct {
+    Scope otlpScope `json:"scope"`
+    LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpResource struct {
+    Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpResourceLogs struct {
+    Resource otlpResource `json:"resource"`
+    ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+func otlpString(s string) otlpAnyValue {
+    return otlpAnyValue{StringValue: &s}
+}
+
+func otlpValue(value interface{}) otlpAnyValue {
+    switch v := value.(type) {
+    case string:
+        return otlpString(v)
+    case bool:
+        return otlpAnyValue{BoolValue: &v}
+    case float64:
+        // json numbers are float64, whole numbers are sent as integers
+        if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+            return otlpAnyValue{IntValue: strconv.FormatInt(int64(v), 10)}
+        }
+        return otlpAnyValue{DoubleValue: &v}
+    case []interface{}:
+        array := &otlpArrayValue{Values: []otlpAnyValue{}}
+        for _, item := range v {
+            array.Values = append(array.Values, otlpValue(item))
+        }
+        return otlpAnyValue{ArrayValue: array}
+    case map[string]interface{}:
+        return otlpAnyValue{KvlistValue: &otlpKvlistValue{Values: otlpAttributes(v)}}
+    }
+    return otlpString(fieldString(value))
+}
+
+// otlpAttributes returns the fields sorted by name
+func otlpAttributes(fields map[string]interface{}) []otlpKeyValue {
+    var names []string
+    for name := range fields {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    attributes := []otlpKeyValue{}
+    for _, name := range names {
+        attributes = append(attributes, otlpKeyValue{Key: name, Value: otlpValue(fields[name])})
+    }
+    return attributes
+}
+
+func otlpResourceAttributes(m *logMessage) []otlpKeyValue {
+    attributes := []otlpKeyValue{
+        {Key: "host.name", Value: otlpString(m.host())},
+        {Key: "service.name", Value: otlpString(m.app())},
+    }
+    for _, t := range m.tags {
+        attributes = append(attributes, otlpKeyValue{Key: t.name, Value: otlpString(t.value)})
+    }
+    return attributes
+}
+
+// encode groups the batch by resource
+func (s *otlpSink) encode(batch []*logMessage) []byte {
+    var resourceLogs []*otlpResourceLogs
+    byResource := make(map[string]*otlpResourceLogs)
+    for _, m := range batch {
+        attributes := otlpResourceAttributes(m)
+        key, _ := json.Marshal(attributes)
+        r := byResource[string(key)]
+        if r == nil {
+            r = &otlpResourceLogs{
+                Resource: otlpResource{Attributes: attributes},
+                ScopeLogs: []otlpScopeLogs{{Scope: otlpScope{Name: appTag, Version: appVersion}}},
+            }
+            byResource[string(key)] = r
+            resourceLogs = append(resourceLogs, r)
+        }
+        record := otlpLogRecord{
+            TimeUnixNano: strconv.FormatInt(m.time.UnixNano(), 10),
+            ObservedTimeUnixNano: strconv.FormatInt(m.time.UnixNano(), 10),
+            SeverityNumber: otlpSeverityNumbers[m.severity&0x07],
+            SeverityText: otlpSeverityTexts[m.severity&0x07],
+            Body: otlpString(m.msg),
+        }
+        if m.fields != nil {
+            record.Attributes = otlpAttributes(m.fields)
+        }
+        r.ScopeLogs[0].LogRecords = append(r.ScopeLogs[0].LogRecords, record)
+    }
+    _byteArray, _ := json.Marshal(map[string]interface{}{"resourceLogs": resourceLogs})
+    return _byteArray
+}
+
+func (s *otlpSink) send(batch []*logMessage) error {
+    _, err := s.poster.post("", s.encode(batch), "application/json")
+    return err
+}
+
+func (s *otlpSink) write(m *logMessage) error {
+    s.batcher.add(m)
+    return nil
+}
+
+func (s *otlpSink) close() error {
+    s.batcher.close()
+    return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestOTLPValue(t *testing.T) {
+    tests := []struct {
+        value interface{}
+        want string
+    }{
+        {"text", `{"stringValue":"text"}`},
+        {true, `{"boolValue":true}`},
+        {float64(42), `{"intValue":"42"}`},
+        {1.5, `{"doubleValue":1.5}`},
+        {[]interface{}{"a", float64(1)}, `{"arrayValue":{"values":[{"stringValue":"a"},{"intValue":"1"}]}}`},
+        {[]interface{}{}, `{"arrayValue":{"values":[]}}`},
+        {map[string]interface{}{"b": "x", "a": false}, `{"kvlistValue":{"values":[{"key":"a","value":{"boolValue":false}},{"key":"b","value":{"stringValue":"x"}}]}}`},
+        {nil, `{"stringValue":"null"}`},
+    }
+    for _, test := range tests {
+        got, err := json.Marshal(otlpValue(test.value))
+        if err != nil {
+            t.Errorf("%v: %s", test.value, err)
+        } else if string(got) != test.want {
+            t.Errorf("%v: got %s, expected %s", test.value, got, test.want)
+        }
+    }
+}
+
+func TestOTLPSink(t *testing.T) {
+    server := newTestServer(nil)
+    defer server.Close()
+    sink := openTestSink(t, openOTLPSink, "otlp+"+server.URL)
+    for _, m := range []*logMessage{
+        {severity: syslog.LOG_INFO, msg: "one", time: testTime, hostname: "web1", appname: "app", tags: []tag{{"env", "prod"}}},
+        {severity: syslog.LOG_WARNING, msg: "two", time: testTime, hostname: "web1", appname: "worker"},
+        {severity: syslog.LOG_ERR, msg: "three", time: testTime, hostname: "web1", appname: "app", tags: []tag{{"env", "prod"}},
+            fields: map[string]interface{}{"status": float64(500)}},
+    } {
+        sink.write(m)
+    }
+    sink.close()
+
+    if len(server.requests) != 1 {
+        t.Fatalf("got %d requests, expected 1", len(server.requests))
+    }
+    if path := server.requests[0].URL.Path; path != "/v1/logs" {
+        t.Errorf("posted to %s, expected /v1/logs", path)
+    }
+    var export struct {
+        ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+    }
+    if err := json.Unmarshal(server.bodies[0], &export); err != nil {
+        t.Fatal(err)
+    }
+    // one resource per hostname, appname and tags
+    if len(export.ResourceLogs) != 2 {
+        t.Fatalf("got %d resources, expected 2: %s", len(export.ResourceLogs), server.bodies[0])
+    }
+    app := export.ResourceLogs[0]
+    resource, _ := json.Marshal(app.Resource.Attributes)
+    if want := `[{"key":"host.name","value":{"stringValue":"web1"}},{"key":"service.name","value":{"stringValue":"app"}},{"key":"env","value":{"stringValue":"prod"}}]`; string(resource) != want {
+        t.Errorf("got resource %s, expected %s", resource, want)
+    }
+    records := app.ScopeLogs[0].LogRecords
+    if len(records) != 2 {
+        t.Fatalf("got %d records, expected 2", len(records))
+    }
+    record, _ := json.Marshal(records[1])
+    if want := `{"timeUnixNano":"1546441445123456000","observedTimeUnixNano":"1546441445123456000","severityNumber":17,"severityText":"ERROR","body":{"stringValue":"three"},"attributes":[{"key":"status","value":{"intValue":"500"}}]}`; string(record) != want {
+        t.Errorf("got record %s, expected %s", record, want)
+    }
+}
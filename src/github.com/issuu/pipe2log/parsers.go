@@ -0,0 +1,124 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "regexp"
+    "strings"
+
+    syslog "github.com/issuu/srslog"
+)
+
+// User defined line parsers are read from the -parsers file, one
+// directive per line, 'name directive value', and used by their name as
+// logformat.
+//
+//   # W 2019/01/02 15:04:05 [disk] disk almost full
+//   vendord pattern ^(?P<severity>[A-Z]) (?P<time>\S+ \S+) \[(?P<component>[^\]]*)\] (?P<msg>.*)$
+//   vendord time 2006/01/02 15:04:05
+//   vendord severity W=warning,E=err,F=crit
+//
+// A pattern is a regular expression with named groups, the severity,
+// time, msg and pid groups make the message and the other groups are
+// added as fields. Without a msg group the whole line is the message.
+// Patterns are tried in order, lines not matching any are scanned for a
+// severity like the default logformat. The time directive gives the Go
+// layouts of the time group. The severity directive maps values of the
+// severity group to severities, values are not case sensitive, values
+// not in the table are looked up as level names, i.e. warn or error.
+
+var flagParserFile string
+
+var parserName_re = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// parseSeverityTable parses value=severity mappings, the values keep
+// their case
+func parseSeverityTable(table string) (map[string]syslog.Priority, error) {
+    severities := make(map[string]syslog.Priority)
+    for _, entry := range splitList(table) {
+        idx := strings.Index(entry, "=")
+        if idx <= 0 {
+            return nil, fmt.Errorf("invalid severity mapping '%s', use value=severity", entry)
+        }
+        severity, err := mapSeverityString(strings.TrimSpace(entry[idx+1:]))
+        if err != nil {
+            return nil, err
+        }
+        severities[strings.TrimSpace(entry[:idx])] = severity
+    }
+    return severities, nil
+}
+
+func loadLineParsers(path string) (map[string]*lineParser, error) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    parsers := make(map[string]*lineParser)
+    s := bufio.NewScanner(bytes.NewReader(content))
+    lineno := 0
+    for s.Scan() {
+        lineno++
+        line := strings.TrimSpace(s.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        parts := strings.SplitN(line, " ", 3)
+        if len(parts) < 3 || strings.TrimSpace(parts[2]) == "" {
+            return nil, fmt.Errorf("%s:%d missing value, use 'name directive value'", path, lineno)
+        }
+        name, directive, value := parts[0], parts[1], strings.TrimSpace(parts[2])
+        if !parserName_re.MatchString(name) || knownLogformat(name) {
+            return nil, fmt.Errorf("%s:%d invalid parser name '%s'", path, lineno, name)
+        }
+        p, ok := parsers[name]
+        if !ok {
+            p = &lineParser{severities: make(map[string]syslog.Priority)}
+            parsers[name] = p
+        }
+        switch directive {
+        case "pattern":
+            re, err := regexp.Compile(value)
+            if err != nil {
+                return nil, fmt.Errorf("%s:%d %s", path, lineno, err)
+            }
+            p.patterns = append(p.patterns, re)
+        case "time":
+            p.timeLayouts = append(p.timeLayouts, value)
+        case "severity":
+            severities, err := parseSeverityTable(value)
+            if err != nil {
+                return nil, fmt.Errorf("%s:%d %s", path, lineno, err)
+            }
+            for v, severity := range severities {
+                p.severities[strings.ToUpper(v)] = severity
+            }
+        default:
+            return nil, fmt.Errorf("%s:%d unknown directive '%s', must be pattern, time or severity", path, lineno, directive)
+        }
+    }
+    if err = s.Err(); err != nil {
+        return nil, err
+    }
+    for name, p := range parsers {
+        if len(p.patterns) == 0 {
+            return nil, fmt.Errorf("%s: parser '%s' has no pattern", path, name)
+        }
+    }
+    return parsers, nil
+}
+
+// lineParserFor returns the user defined or named text format parser
+func lineParserFor(format string) *lineParser {
+    if p, ok := rules.parsers[format]; ok {
+        return p
+    }
+    return textFormats[format]
+}
+
+// validLogformat is a named or user defined logformat
+func validLogformat(format string, parsers map[string]*lineParser) bool {
+    return knownLogformat(format) || parsers[format] != nil
+}
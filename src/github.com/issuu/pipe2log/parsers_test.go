@@ -0,0 +1,138 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+// writeTestParsers writes content to a temporary parser file, removed by
+// the caller
+func writeTestParsers(t *testing.T, content string) string {
+    f, err := ioutil.TempFile("", "pipe2log-parsers")
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.WriteString(content)
+    f.Close()
+    return f.Name()
+}
+
+const testParsers = `
+# W 2019/01/02 15:04:05 [disk] disk almost full
+vendord pattern ^(?P<severity>[A-Z]) (?P<time>\S+ \S+) \[(?P<component>[^\]]*)\] (?P<msg>.*)$
+vendord time 2006/01/02 15:04:05
+vendord severity W=warning, e=err,F=crit
+# without a msg group the line is the message
+vendord pattern ^pid (?P<pid>\d+) (?P<severity>\w+)
+`
+
+func TestLoadLineParsers(t *testing.T) {
+    path := writeTestParsers(t, testParsers)
+    defer os.Remove(path)
+    parsers, err := loadLineParsers(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    p := parsers["vendord"]
+    if len(parsers) != 1 || p == nil || len(p.patterns) != 2 || len(p.timeLayouts) != 1 {
+        t.Fatalf("got %v", parsers)
+    }
+    tests := []struct {
+        line string
+        severity syslog.Priority
+        found bool
+        msg string
+        procid string
+        component string
+    }{
+        {"W 2019/01/02 15:04:05 [disk] disk almost full", syslog.LOG_WARNING, true, "disk almost full", "", "disk"},
+        // the values of the severity table aren't case sensitive
+        {"E 2019/01/02 15:04:05 [db] connection lost", syslog.LOG_ERR, true, "connection lost", "", "db"},
+        {"X 2019/01/02 15:04:05 [db] what", syslog.LOG_INFO, false, "what", "", "db"},
+        // values not in the table are level names
+        {"pid 42 debug starting", syslog.LOG_DEBUG, true, "pid 42 debug starting", "42", ""},
+    }
+    for _, test := range tests {
+        lm, found := p.parse([]byte(test.line))
+        if lm == nil {
+            t.Errorf("%s: not parsed", test.line)
+            continue
+        }
+        component, _ := lm.fields["component"].(string)
+        if lm.severity != test.severity || found != test.found || lm.msg != test.msg || lm.procid != test.procid || component != test.component {
+            t.Errorf("%s: got %d %v %q %q %v", test.line, lm.severity, found, lm.msg, lm.procid, lm.fields)
+        }
+    }
+    if lm, _ := p.parse([]byte("W 2019/01/02 15:04:05 [disk] x")); !lm.time.Equal(time.Date(2019, 1, 2, 15, 4, 5, 0, time.Local)) {
+        t.Errorf("got time %s", lm.time)
+    }
+    if lm, _ := p.parse([]byte("something else")); lm != nil {
+        t.Errorf("got %+v for a line not matching", lm)
+    }
+}
+
+func TestLoadLineParsersErrors(t *testing.T) {
+    tests := []struct {
+        content string
+        expected string
+    }{
+        {"vendord pattern", ":1 missing value"},
+        {"vendord pattern (unclosed", ":1 error parsing regexp"},
+        {"\nvendord layout %d", ":2 unknown directive 'layout'"},
+        {"vendord severity W", ":1 invalid severity mapping 'W'"},
+        {"vendord severity W=sometimes", ":1 Unsupported severity 'sometimes'"},
+        {"log4j pattern ^(?P<msg>.*)$", ":1 invalid parser name 'log4j'"},
+        {"vendor/d pattern ^(?P<msg>.*)$", ":1 invalid parser name 'vendor/d'"},
+        {"vendord time 2006/01/02", "parser 'vendord' has no pattern"},
+    }
+    for _, test := range tests {
+        path := writeTestParsers(t, test.content)
+        _, err := loadLineParsers(path)
+        os.Remove(path)
+        if err == nil || !strings.Contains(err.Error(), test.expected) {
+            t.Errorf("%q: got %v, expected %s", test.content, err, test.expected)
+        }
+    }
+    if _, err := loadLineParsers("/nonexistent/parsers"); err == nil {
+        t.Errorf("expected an error for a missing file")
+    }
+}
+
+func TestParseLogLineUserParser(t *testing.T) {
+    _, restore := useTestPipeline(t)
+    defer restore()
+    path := writeTestParsers(t, testParsers)
+    defer os.Remove(path)
+    var err error
+    if rules.parsers, err = loadLineParsers(path); err != nil {
+        t.Fatal(err)
+    }
+    if !validLogformat("vendord", rules.parsers) || validLogformat("vendore", rules.parsers) {
+        t.Errorf("vendord should be the only valid user defined logformat")
+    }
+
+    tests := []struct {
+        line string
+        severity syslog.Priority
+        found bool
+        msg string
+    }{
+        {"F 2019/01/02 15:04:05 [disk] gone", syslog.LOG_CRIT, true, "gone"},
+        // the default severity when the severity group has none
+        {"X 2019/01/02 15:04:05 [disk] what", syslog.LOG_INFO, false, "what"},
+        // lines not matching are scanned for a severity
+        {"WARNING: not in the layout", syslog.LOG_WARNING, true, " not in the layout"},
+        {"not in the layout", syslog.LOG_INFO, false, "not in the layout"},
+    }
+    for _, test := range tests {
+        lm, found := parseLogLine("vendord", []byte(test.line))
+        if lm == nil || lm.severity != test.severity || found != test.found || lm.msg != test.msg {
+            t.Errorf("%s: got %+v %v", test.line, lm, found)
+        }
+    }
+}
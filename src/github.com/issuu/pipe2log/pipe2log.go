@@ -4,17 +4,19 @@ import (
     "bytes"
     "flag"
     "fmt"
-    "regexp"
     "time"
     "bufio"
     "encoding/json"
     "log"
+    "net"
     //"log/syslog" // use the better and extended version of syslog
     syslog "github.com/issuu/srslog"
     "os"
     "os/exec"
-    url "net/url"
+    "os/signal"
+    "strconv"
     "strings"
+    "syscall"
 )
 
 const appTag = "pipe2log"
@@ -44,38 +46,150 @@ var flagLogformat string
 var flagVersion bool
 var flagRFC3164 bool
 var flagRFC3339 bool
-
-// if using local log device we can't set/change hostname
-var localLogging bool
+var flagMinLevel string
+
+
+// logMessage is a parsed message ready for sending
+type logMessage struct {
+    severity syslog.Priority
+    msg string
+    // additional fields from json input
+    fields map[string]interface{}
+    // static tags added to every message
+    tags []tag
+    time time.Time
+    // set for messages received by the syslog listener
+    hostname string
+    appname string
+    procid string
+    msgid string
+    // rfc5424 structured data, as received
+    structuredData string
+    // set for received messages and messages queued for a batch
+    facility string
+}
+// text is the message as sent to syslog, additional fields are appended as json
+func (m *logMessage) text() string {
+    if len(m.fields) > 0 {
+        _byteArray, _ := json.Marshal(m.fields)
+        return fmt.Sprintf("%s %s", m.msg, _byteArray)
+    }
+    return m.msg
+}
+// host is the hostname to report for the message
+func (m *logMessage) host() string {
+    if m.hostname != "" {
+        return m.hostname
+    }
+    if h := sourceHostname(); h != "" {
+        return h
+    }
+    return os_hostname
+}
+// app is the application name to report for the message
+func (m *logMessage) app() string {
+    if m.appname != "" {
+        return m.appname
+    }
+    if flagSyslogAppname != "" {
+        return flagSyslogAppname
+    }
+    return os.Args[0]
+}
+// facilityName is the syslog facility to report for the message
+func (m *logMessage) facilityName() string {
+    if m.facility != "" {
+        return m.facility
+    }
+    return flagSyslogFacility
+}
+// priority is p with the facility of the message, if it has its own
+func (m *logMessage) priority(p syslog.Priority) syslog.Priority {
+    for code, name := range facilityNames {
+        if name != "" && name == m.facility {
+            return syslog.Priority(code<<3) | p&0x07
+        }
+    }
+    return p
+}
+// procID is the process id to report for the message, the parent
+// process is the program piping its output to us. Empty for received
+// messages without a process id.
+func (m *logMessage) procID() string {
+    if m.procid != "" || m.appname != "" {
+        return m.procid
+    }
+    return strconv.Itoa(os.Getppid())
+}
 
 
 type logWrapper struct{
-    useConsole bool
-    syslogWriter *syslog.Writer
+    destinations []*logDestination
+}
+// setDefaults adds the tags and the time to a message without them
+func (m *logMessage) setDefaults() {
+    if m.tags == nil {
+        m.tags = rules.tags
+    }
+    if m.time.IsZero() {
+        m.time = time.Now()
+    }
+}
+// write sends m to every destination accepting its severity
+func (l *logWrapper) write(m *logMessage) {
+    l.send(m, true)
+}
+// send sends m to every destination accepting its severity, past the rate
+// limits of the destinations unless limited
+func (l *logWrapper) send(m *logMessage, limited bool) {
+    m.setDefaults()
+    for _, d := range l.destinations {
+        if m.severity > d.minSeverity {
+            continue
+        }
+        if limited && d.limiter != nil && !d.limiter.allow(m.severity) {
+            continue
+        }
+        d.sink.write(m)
+    }
+}
+func (l *logWrapper) log(p syslog.Priority, msg string) {
+    l.write(&logMessage{severity: p, msg: msg})
 }
 func (l *logWrapper) Alert(msg string) {
-    if (l.useConsole) {fmt.Println("ALERT "+msg)} else {l.syslogWriter.Alert(msg)}
+    l.log(syslog.LOG_ALERT, msg)
 }
 func (l *logWrapper) Crit(msg string) {
-    if (l.useConsole) {fmt.Println("CRITICAL "+msg)} else {l.syslogWriter.Crit(msg)}
+    l.log(syslog.LOG_CRIT, msg)
 }
 func (l *logWrapper) Err(msg string) {
-    if (l.useConsole) {fmt.Println("ERROR "+msg)} else {l.syslogWriter.Err(msg)}
+    l.log(syslog.LOG_ERR, msg)
 }
 func (l *logWrapper) Warning(msg string) {
-    if (l.useConsole) {fmt.Println("WARNING "+msg)} else {l.syslogWriter.Warning(msg)}
+    l.log(syslog.LOG_WARNING, msg)
 }
 func (l *logWrapper) Notice(msg string) {
-    if (l.useConsole) {fmt.Println("NOTICE "+msg)} else {l.syslogWriter.Notice(msg)}
+    l.log(syslog.LOG_NOTICE, msg)
 }
 func (l *logWrapper) Info(msg string) {
-    if (l.useConsole) {fmt.Println("INFO "+msg)} else {l.syslogWriter.Info(msg)}
+    l.log(syslog.LOG_INFO, msg)
 }
 func (l *logWrapper) Debug(msg string) {
-    if (l.useConsole) {fmt.Println("DEBUG "+msg)} else {l.syslogWriter.Debug(msg)}
+    l.log(syslog.LOG_DEBUG, msg)
+}
+// closeExcept closes all destinations whose sink is not used by other
+func (l *logWrapper) closeExcept(other *logWrapper) {
+    loop:for _, d := range l.destinations {
+        for _, o := range other.destinations {
+            if o.sink == d.sink {
+                continue loop
+            }
+        }
+        d.sink.close()
+    }
 }
 func (l *logWrapper) Close() {
-    if (!l.useConsole) {l.syslogWriter.Close()}
+    l.closeExcept(&logWrapper{})
 }
 var logWriter logWrapper
 
@@ -109,7 +223,7 @@ type pinoMessage struct {
     Stack string
     Hostname string
     Process_id int64
-    Extra string
+    Extra map[string]interface{}
 }
 type pinoMessage1 struct {
     Message string      `json:"msg"`
@@ -127,71 +241,99 @@ const RFC3339Micro = "2006-01-02T15:04:05.999999Z07:00"
 // RFC5424Formatter provides an RFC 5424 compliant message.
 // create our own customized version
 func issuuRFC5424Formatter(p syslog.Priority, hostname, appname, content string) string {
+    return formatRFC5424(p, &logMessage{time: time.Now(), appname: appname}, content)
+}
+
+// formatRFC5424 formats m with content as the message, the tags are added
+// as structured data.
+func formatRFC5424(p syslog.Priority, m *logMessage, content string) string {
     // SYSLOG-MSG      = HEADER SP STRUCTURED-DATA [SP MSG]
     // HEADER          = PRI VERSION SP TIMESTAMP SP HOSTNAME
     //                   SP APP-NAME SP PROCID SP MSGID
     // https://tools.ietf.org/html/rfc5424
-    msgid := "-"            // syslog nil value
-    structured_data := "-"  // syslog nil value
-    timestamp := time.Now().Format(RFC3339Micro)
-    pid := os.Getppid()
-    if flagSyslogHostname != "" {
-        if strings.HasPrefix(flagSyslogHostname,"+") {
-            hostname = flagSyslogHostname[1:] + "." + os_hostname
-        } else {
-            hostname = flagSyslogHostname
-        }
+    p = m.priority(p)
+    msgid := m.msgid
+    if msgid == "" {
+        msgid = "-"            // syslog nil value
     }
+    structured_data := structuredData(m.tags) + m.structuredData
+    if structured_data == "" {
+        structured_data = "-"  // syslog nil value
+    }
+    timestamp := m.time.Format(RFC3339Micro)
+    hostname := m.host()
     if hostname == "" {
         hostname = "-"  // syslog nil value
     }
-    if appname == "" {
-        appname = os.Args[0]
+    procid := m.procID()
+    if procid == "" {
+        procid = "-"  // syslog nil value
     }
-    msg := fmt.Sprintf("<%d>%d %s %s %s %d %s %s %s",
-        p, 1, timestamp, hostname, appname, pid, msgid, structured_data, content)
+    msg := fmt.Sprintf("<%d>%d %s %s %s %s %s %s %s",
+        p, 1, timestamp, hostname, m.app(), procid, msgid, structured_data, content)
     //fmt.Println(msg)
     return msg
 }
 
+// sourceHostname is the hostname to use in messages, empty if the
+// hostname is not overridden.
+func sourceHostname() string {
+    hostname := flagSyslogHostname
+    if strings.HasPrefix(hostname,"+") {
+        hostname = hostname[1:] + "." + os_hostname
+    }
+    // i.e. the detected container id
+    if rules.hostnamePrefix != "" && !strings.HasPrefix(hostname, rules.hostnamePrefix) {
+        if hostname == "" {
+            hostname = os_hostname
+        }
+        hostname = rules.hostnamePrefix + "." + hostname
+    }
+    return hostname
+}
+
 // the original spec timestamp
 const RFC3164 = "Jan 02 15:04:05"
 
 // RFC3164ormatter provides an RFC 3164 message with RFC3339 timestamp.
 // create our own customized version
 func issuuRFC3164Formatter(p syslog.Priority, hostname, appname, content string) string {
+    return formatRFC3164(p, &logMessage{time: time.Now(), appname: appname}, content, false)
+}
+
+// if using local log device we can't set/change hostname
+func issuuLocalRFC3164Formatter(p syslog.Priority, hostname, appname, content string) string {
+    return formatRFC3164(p, &logMessage{time: time.Now(), appname: appname}, content, true)
+}
+
+func formatRFC3164(p syslog.Priority, m *logMessage, content string, localLogging bool) string {
     // SYSLOG-MSG      = PRI HEADER SP MSG
     // HEADER          = TIMESTAMP SP HOSTNAME_OR_IP
     // MSG             = TAG CONTENT
     // TIMESTAMP       = Mmm dd hh:mm:ss
     // https://tools.ietf.org/html/rfc3164
+    p = m.priority(p)
     var timestamp string
     if flagRFC3339 {
-        timestamp = time.Now().Format(RFC3339Milli)
+        timestamp = m.time.Format(RFC3339Milli)
     } else {
-        timestamp = time.Now().Format(RFC3164)
-    }
-    pid := os.Getppid()
-    if flagSyslogHostname != "" {
-        if strings.HasPrefix(flagSyslogHostname,"+") {
-            hostname = flagSyslogHostname[1:] + "." + os_hostname
-        } else {
-            hostname = flagSyslogHostname
-        }
+        timestamp = m.time.Format(RFC3164)
     }
+    hostname := m.host()
     if hostname == "" {
         hostname = "-"  // syslog nil value ? should be ip no
     }
-    if appname == "" {
-        appname = os.Args[0]
+    tag := m.app()
+    if procid := m.procID(); procid != "" {
+        tag += "[" + procid + "]"
     }
     var msg string
     if localLogging {
-        msg = fmt.Sprintf("<%d>%s %s[%d]: %s",
-            p, timestamp, appname, pid, content)
+        msg = fmt.Sprintf("<%d>%s %s: %s",
+            p, timestamp, tag, content)
     } else {
-        msg = fmt.Sprintf("<%d>%s %s %s[%d]: %s",
-            p, timestamp, hostname, appname, pid, content)
+        msg = fmt.Sprintf("<%d>%s %s %s: %s",
+            p, timestamp, hostname, tag, content)
     }
     return msg
 }
@@ -206,6 +348,12 @@ type scandata struct {
   fdno int
   err error
   data []byte
+  // where a tailed file is read up to after this data
+  pos *tailPosition
+  // the -input the data is from
+  source *inputSource
+  // the connection to a stream socket input the data is from
+  conn net.Conn
 }
 
 func inputScanner(dc chan scandata, fdno int, s *bufio.Scanner) {
@@ -329,17 +477,75 @@ func ScanJSON(data []byte, atEOF bool) (advance int, scantoken []byte, err error
     return 0, nil, nil
 }
 
-var severity_re = regexp.MustCompile("^[ ]*([0-9- /:.]*)[[]?((DEBUG|INFO|NOTICE|WARN|WARNING|ERR|ERROR|CRIT|CRITICAL|ALERT))[]]?[ :](.*)$")
+func knownLogformat(format string) bool {
+    return format == "" || format == "pm2json" || format == "pm2log" || format == "pino" || format == "logfmt" || textLogformat(format) || containerLogformat(format)
+}
+
+// json based logformats are split on curly brackets instead of newlines
+func jsonLogformat(format string) bool {
+    return format == "pm2json" || format == "pm2log" || format == "pino"
+}
+
+// scanSplit picks the split function matching the current logformat,
+// which can change on a configuration reload.
+func scanSplit(data []byte, atEOF bool) (advance int, scantoken []byte, err error) {
+    format, _ := scanFormat.Load().(string)
+    if jsonLogformat(format) {
+        return ScanJSON(data, atEOF)
+    }
+    return ScanLines(data, atEOF)
+}
 
 func processScanData(data scandata) {
+    var lm *logMessage
+    format := data.source.logformat()
+    if containerLogformat(format) {
+        lm = parseContainerLog(format, data)
+    } else {
+        lm, _ = parseLogLine(format, data.data)
+    }
+    if lm == nil {
+        // only part of a line
+        return
+    }
+    if data.source != nil && data.source.appname != "" {
+        lm.appname = data.source.appname
+    }
+    processMessage(lm)
+}
+
+// parseLogLine parses data in the logformat, found is false when the
+// data has no severity and the default severity is used.
+func parseLogLine(format string, data []byte) (lm *logMessage, found bool) {
+    found = true
     switch {
-    case flagLogformat == "pm2json" || flagLogformat == "pm2log":
+    case lineParserFor(format) != nil:
+        lm, found = lineParserFor(format).parse(data)
+        if lm != nil {
+            if !found {
+                lm.severity = rules.severities.defaultSeverity
+            }
+            return lm, found
+        }
+        // not in the layout, scan for a severity
+        return parseLogLine("", data)
+    case format == "logfmt":
+        lm, found = parseLogfmt(data)
+        if lm != nil {
+            if !found {
+                lm.severity = rules.severities.defaultSeverity
+            }
+            return lm, found
+        }
+        // not logfmt, scan for a severity
+        return parseLogLine("", data)
+    case format == "pm2json" || format == "pm2log":
         var m pm2Message
         var m1 pm2Message1
-        err := json.Unmarshal(data.data, &m1)
+        err := json.Unmarshal(data, &m1)
         if err != nil {
             var m2 pm2Message2
-            err = json.Unmarshal(data.data, &m2)
+            err = json.Unmarshal(data, &m2)
             if err == nil {
                 m.Type = m2.Type
                 m.Message = m2.Message
@@ -358,26 +564,26 @@ func processScanData(data scandata) {
             //fmt.Printf("decoded type: %s, message: %s\n",m.Type,m.Message)
             switch {
             case m.Type == "PM2":
-                logWriter.Crit(m.Message)
+                lm = &logMessage{severity: syslog.LOG_CRIT, msg: m.Message}
             case m.Type == "err":
-                logWriter.Err(m.Message)
+                lm = &logMessage{severity: syslog.LOG_ERR, msg: m.Message}
             case m.Type == "out":
-                logWriter.Info(m.Message)
+                lm = &logMessage{severity: syslog.LOG_INFO, msg: m.Message}
             case m.Type == "process_event":
                 logmsg := fmt.Sprintf("%s: %s", m.Type, m.Status)
-                logWriter.Debug(logmsg)
+                lm = &logMessage{severity: syslog.LOG_DEBUG, msg: logmsg}
             default:
-                logmsg := fmt.Sprintf("%s unknown pm2 log type '%s', data: '%s'", appTagVersion, m.Type, data.data)
-                logWriter.Crit(logmsg)
+                logmsg := fmt.Sprintf("%s unknown pm2 log type '%s', data: '%s'", appTagVersion, m.Type, data)
+                lm = &logMessage{severity: syslog.LOG_CRIT, msg: logmsg}
             }
         } else {
-            logmsg := fmt.Sprintf("%s decoding error cannot parse json '%s', err '%s'", appTagVersion, data.data, err)
-            logWriter.Warning(logmsg)
+            logmsg := fmt.Sprintf("%s decoding error cannot parse json '%s', err '%s'", appTagVersion, data, err)
+            lm = &logMessage{severity: syslog.LOG_WARNING, msg: logmsg}
         }
-    case flagLogformat == "pino":
+    case format == "pino":
         var m pinoMessage
         var m1 pinoMessage1
-        err := json.Unmarshal(data.data, &m1)
+        err := json.Unmarshal(data, &m1)
         if err == nil {
             m.Level = m1.Level
             m.Type = m1.Type
@@ -385,8 +591,7 @@ func processScanData(data scandata) {
             m.Stack = m1.Stack
             m.Process_id = m1.Process_id
             m.Hostname = m1.Hostname
-            m.Extra = ""
-            err = json.Unmarshal(data.data, &m1.Extra)
+            err = json.Unmarshal(data, &m1.Extra)
             if err == nil {
                 // remove values we already have
                 delete(m1.Extra, "v")
@@ -397,82 +602,120 @@ func processScanData(data scandata) {
                 delete(m1.Extra, "type")
                 delete(m1.Extra, "stack")
                 delete(m1.Extra, "hostname")
-                if len(m1.Extra) > 0 {
-                    _byteArray, _ := json.Marshal(m1.Extra)
-                    m.Extra = fmt.Sprintf(" %s", _byteArray)
-                }
+                m.Extra = m1.Extra
             }
         }
         if err == nil {
             switch {
             case m.Type == "Error":
-                logWriter.Err(m.Stack + m.Extra)
+                lm = &logMessage{severity: syslog.LOG_ERR, msg: m.Stack, fields: m.Extra}
             case m.Type == "" && m.Level >= 50:
-                logWriter.Err(m.Message + m.Extra)
+                lm = &logMessage{severity: syslog.LOG_ERR, msg: m.Message, fields: m.Extra}
             case m.Type == "" && m.Level >= 40:
-                logWriter.Warning(m.Message + m.Extra)
+                lm = &logMessage{severity: syslog.LOG_WARNING, msg: m.Message, fields: m.Extra}
             case m.Type == "" && m.Level >= 30:
-                logWriter.Info(m.Message + m.Extra)
+                lm = &logMessage{severity: syslog.LOG_INFO, msg: m.Message, fields: m.Extra}
             case m.Type == "" && m.Level >= 20:
-                logWriter.Debug(m.Message + m.Extra)
+                lm = &logMessage{severity: syslog.LOG_DEBUG, msg: m.Message, fields: m.Extra}
             default:
-                logmsg := fmt.Sprintf("%s unknown pino log type '%s', level: %d, data: '%s'", appTagVersion, m.Type, m.Level, data.data)
-                logWriter.Crit(logmsg)
+                logmsg := fmt.Sprintf("%s unknown pino log type '%s', level: %d, data: '%s'", appTagVersion, m.Type, m.Level, data)
+                lm = &logMessage{severity: syslog.LOG_CRIT, msg: logmsg}
             }
         } else {
-            logmsg := fmt.Sprintf("%s decoding error cannot parse json '%s', err '%s'", appTagVersion, data.data, err)
-            logWriter.Warning(logmsg)
+            logmsg := fmt.Sprintf("%s decoding error cannot parse json '%s', err '%s'", appTagVersion, data, err)
+            lm = &logMessage{severity: syslog.LOG_WARNING, msg: logmsg}
         }
     default:
-        rs := severity_re.FindSubmatch(data.data)
-        if rs != nil {
-            severity := fmt.Sprintf("%s",rs[3])
-            msg := fmt.Sprintf("%s%s",rs[1],rs[4])
-            switch {
-            case "DEBUG" == severity:
-               logWriter.Debug(msg)
-            case "INFO" == severity:
-               logWriter.Info(msg)
-            case "NOTICE" == severity:
-               logWriter.Notice(msg)
-            case "WARN" == severity || "WARNING" == severity:
-               logWriter.Warning(msg)
-            case "ERR" == severity || "ERROR" == severity:
-               logWriter.Err(msg)
-            case "CRIT" == severity || "CRITICAL" == severity:
-               logWriter.Crit(msg)
-            case "ALERT" == severity:
-               logWriter.Alert(msg)
-            default:
-               // should never ever happen
-               logmsg := fmt.Sprintf("%s unknown severity '%s' with msg '%s'", appTagVersion, severity, msg)
-               logWriter.Crit(logmsg)
-               //log.Fatalln(logmsg)
-            }
-        } else {
-            // use default log severity ? a command option/flag ?
-            msg := fmt.Sprintf("%s",data.data)
-            logWriter.Info(msg)
-        }
+        lm, found = rules.severities.scan(data)
     }
+    return lm, found
 }
 
 func scanPipeLog() {
-    var r1  *bufio.Scanner
-    r1 = bufio.NewScanner(os.Stdin)
-
-    if flagLogformat == "pm2json" || flagLogformat == "pm2log" || flagLogformat == "pino" {
-        r1.Split(ScanJSON)
+    var dc1 chan scandata
+    // messages from the syslog listeners
+    var received chan *logMessage
+    // messages from the tailed files
+    var dc2 chan scandata
+    // messages from the fifo and socket inputs
+    var dc3 chan scandata
+    var state *tailState
+    // a syslog server or tail runs until it is stopped
+    var term chan os.Signal
+    if flagListen != "" {
+        received = make(chan *logMessage, receivedQueueSize)
+        listeners, err := startListeners(flagListen, received)
+        checkError(err)
+        defer closeListeners(listeners)
+    }
+    if flagTail != "" {
+        var err error
+        state, err = loadTailState(flagTailState)
+        checkError(err)
+        dc2 = make(chan scandata, startBufSize)
+        tail, err := startTailer(flagTail, state, dc2)
+        checkError(err)
+        defer func() {
+            tail.close()
+            if err := state.save(); err != nil {
+                log.Printf("%s tail state %s: %s\n", appTagVersion, flagTailState, err)
+            }
+        }()
+    }
+    if flagInput != "" {
+        dc3 = make(chan scandata, startBufSize)
+        sources, err := startInputs(flagInput, dc3)
+        checkError(err)
+        defer closeInputs(sources)
+    }
+    if flagListen != "" || flagTail != "" || flagInput != "" {
+        term = make(chan os.Signal, 1)
+        signal.Notify(term, syscall.SIGINT, syscall.SIGTERM)
+        defer signal.Stop(term)
     } else {
-        r1.Split(ScanLines)
+        var r1  *bufio.Scanner
+        r1 = bufio.NewScanner(os.Stdin)
+        r1.Split(scanSplit)
+
+        // Set channel buffer to same size as our io buffer
+        dc1 = make(chan scandata, startBufSize)
+        go inputScanner(dc1, 1, r1)
     }
 
-    // Set channel buffer to same size as our io buffer
-    dc1 := make(chan scandata, startBufSize)
-    go inputScanner(dc1, 1, r1)
+    // reload configuration on SIGHUP
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    defer signal.Stop(hup)
+    // reopen files on SIGUSR1, i.e. from logrotate
+    usr1 := make(chan os.Signal, 1)
+    signal.Notify(usr1, syscall.SIGUSR1)
+    defer signal.Stop(usr1)
+
+    summaryTicker := time.NewTicker(flagRateSummary)
+    defer summaryTicker.Stop()
+    dedupTicker := time.NewTicker(time.Second)
+    defer dedupTicker.Stop()
 
     loop:for {
         select {
+        case <- hup:
+            reloadConfig()
+        case <- usr1:
+            reopenFiles()
+        case <- summaryTicker.C:
+            sendRateLimitSummaries()
+        case <- dedupTicker.C:
+            flushRepeatedMessages(false)
+            flushPartialLines(false)
+        case <- term:
+            break loop
+        case m := <- received:
+            processMessage(m)
+        case data := <- dc2:
+            processScanData(data)
+            state.update(*data.pos)
+        case data := <- dc3:
+            processScanData(data)
         case data, ok := <- dc1:
             if ok {
                 processScanData(data)
@@ -485,6 +728,7 @@ func scanPipeLog() {
             time.Sleep(10 * time.Millisecond)
         }
     }
+    flushPartialLines(true)
 }
 
 func scanCommand() {
@@ -509,13 +753,8 @@ func scanCommand() {
     r1 = bufio.NewScanner(p1)
     r2 = bufio.NewScanner(p2)
 
-    if flagLogformat == "pm2json" || flagLogformat == "pm2log" || flagLogformat == "pino" {
-        r1.Split(ScanJSON)
-        r2.Split(ScanJSON)
-    } else {
-        r1.Split(ScanLines)
-        r2.Split(ScanLines)
-    }
+    r1.Split(scanSplit)
+    r2.Split(scanSplit)
 
     err = cmd.Start()
     checkError(err)
@@ -526,36 +765,59 @@ func scanCommand() {
     // also collect programs exit status and use this for exiting
 }
 
-func mapFacilityString(facility string) syslog.Priority {
+// facility names by their code, for received messages
+var facilityNames = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+    "uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+    "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+func mapFacilityString(facility string) (syslog.Priority, error) {
     switch facility {
     case "daemon":
-        return syslog.LOG_DAEMON
+        return syslog.LOG_DAEMON, nil
     case "user":
-        return syslog.LOG_USER
+        return syslog.LOG_USER, nil
     case "syslog":
-        return syslog.LOG_SYSLOG
+        return syslog.LOG_SYSLOG, nil
     case "local0":
-        return syslog.LOG_LOCAL0
+        return syslog.LOG_LOCAL0, nil
     case "local1":
-        return syslog.LOG_LOCAL1
+        return syslog.LOG_LOCAL1, nil
     case "local2":
-        return syslog.LOG_LOCAL2
+        return syslog.LOG_LOCAL2, nil
     case "local3":
-        return syslog.LOG_LOCAL3
+        return syslog.LOG_LOCAL3, nil
     case "local4":
-        return syslog.LOG_LOCAL4
+        return syslog.LOG_LOCAL4, nil
     case "local5":
-        return syslog.LOG_LOCAL5
+        return syslog.LOG_LOCAL5, nil
     case "local6":
-        return syslog.LOG_LOCAL6
+        return syslog.LOG_LOCAL6, nil
     case "local7":
-        return syslog.LOG_LOCAL7
-    default:
-        log.Fatalf("Unsupported facility '%s', daemon, user, syslog, local[0-7] are supported.", facility);
-        os.Exit(1)
+        return syslog.LOG_LOCAL7, nil
     }
-    // should never happen - but to keep go compiler happy
-    return syslog.LOG_LOCAL4
+    return syslog.LOG_LOCAL4, fmt.Errorf("Unsupported facility '%s', daemon, user, syslog, local[0-7] are supported.", facility)
+}
+
+func mapSeverityString(severity string) (syslog.Priority, error) {
+    switch strings.ToLower(severity) {
+    case "emerg", "emergency":
+        return syslog.LOG_EMERG, nil
+    case "alert":
+        return syslog.LOG_ALERT, nil
+    case "crit", "critical":
+        return syslog.LOG_CRIT, nil
+    case "err", "error":
+        return syslog.LOG_ERR, nil
+    case "warn", "warning":
+        return syslog.LOG_WARNING, nil
+    case "notice":
+        return syslog.LOG_NOTICE, nil
+    case "info":
+        return syslog.LOG_INFO, nil
+    case "debug":
+        return syslog.LOG_DEBUG, nil
+    }
+    return syslog.LOG_DEBUG, fmt.Errorf("Unsupported severity '%s', emerg, alert, crit, err, warning, notice, info, debug are supported.", severity)
 }
 
 func init() {
@@ -574,15 +836,42 @@ func init() {
     flag.BoolVar(&flagVersion, "version", false, "prints current app version")
     flag.BoolVar(&flagRFC3164, "rfc3164", false, "use original syslog rfc3164 msg format (default is to use rfc5424)")
     flag.BoolVar(&flagRFC3339, "rfc3339", false, "use rfc3339 timestamp (milliseconds) with rfc3164 message format")
-    flag.StringVar(&flagSyslogUri, "sysloguri", defaultSyslogUri, "syslog host, i.e. localhost, /dev/log, (udp|tcp)://localhost[:514]. When using local log device /dev/log you can't change/set the hostname in the message. Local logging also implies rfc3164 format. Use 'console' for logging to stdout.")
+    flag.StringVar(&flagSyslogUri, "sysloguri", defaultSyslogUri, "syslog host, i.e. localhost, /dev/log, (udp|tcp)://localhost[:514], tcp+tls://localhost[:6514]. When using local log device /dev/log you can't change/set the hostname in the message. Local logging also implies rfc3164 format. Use 'console' for logging to stdout. Several destinations can be given separated by a comma, destination options are given as uri query parameters, i.e. tcp://logserver?minlevel=warning,console")
+    flag.StringVar(&flagMinLevel, "minlevel", "debug", "minimum severity to log, i.e. debug, info, notice, warning, err, crit, alert. Can be set per destination with the minlevel option.")
     flag.StringVar(&flagSyslogFacility, "facility", defaultSyslogFacility, "what syslog facility to use.")
     flag.StringVar(&flagSyslogAppname, "appname", defaultSyslogAppname, "what application name to use in syslog message.")
     flag.StringVar(&flagSyslogHostname, "hostname", defaultSyslogHostname, "what source/hostname to use in syslog message, use a plus '+' prefix to combine the source with current existing hostname, useful for docker container ids.")
-    flag.StringVar(&flagLogformat, "logformat", defaultLogformat, "default behaviour is to scan for severity, i.e. ERROR,DEBUG,CRIT,.. in the beginning of every line of input. Other options for logformat are 'pm2json' and 'pino' for parsing NodeJs PM2/pino json output.")
+    flag.StringVar(&flagLogformat, "logformat", defaultLogformat, "default behaviour is to scan for severity, i.e. ERROR,DEBUG,CRIT,.. in the beginning of every line of input. Other options for logformat are 'pm2json' and 'pino' for parsing NodeJs PM2/pino json output, 'logfmt' for key=value lines, 'python', 'log4j', 'glog', 'klog' and 'rails' for their text layouts, and 'docker' and 'cri' for container log files.")
+    flag.StringVar(&flagSeverityAliases, "severityaliases", "", "comma separated list of keyword=severity to scan for besides DEBUG, INFO, WARN, ERROR, FATAL,.. i.e. W=warning,E=err,SEVERE=err. A single letter only matches followed by a colon or between brackets.")
+    flag.BoolVar(&flagSeverityIgnoreCase, "severityignorecase", false, "match the severity keywords case insensitive, i.e. error: or Warn.")
+    flag.IntVar(&flagSeveritySearch, "severitysearch", 0, "look for the severity keyword as a word in this many first characters of a line, not only at the start. The line is not changed.")
+    flag.StringVar(&flagDefaultSeverity, "defaultseverity", "info", "the severity of lines without a severity keyword.")
+    flag.StringVar(&flagParserFile, "parsers", "", "read user defined line parsers from this file, used by their name as logformat, see README.md for the format.")
+    flag.StringVar(&flagInnerFormat, "innerformat", "", "the logformat of the lines in docker and cri container logs, i.e. pino or logfmt. Default is to scan for severity.")
     flag.StringVar(&flagCommand, "cmd", defaultCommand, "currently can't be used for anything else than reading from pipe.")
+    flag.StringVar(&flagFilterFile, "filters", "", "read message filter rules from this file, see README.md for the rule format.")
+    flag.StringVar(&flagRateLimit, "ratelimit", "", "limit the rate of messages per severity, i.e. 'info=100/s:500;debug=10/s' or '1000/m' for every severity, see README.md. Can be set per destination with the ratelimit option.")
+    flag.DurationVar(&flagRateSummary, "ratesummary", 10*time.Second, "how often to send a summary of messages suppressed by the rate limits.")
+    flag.StringVar(&flagRedact, "redact", "", "redact secrets and personal data from messages with these detectors, a comma separated list of: all, jwt, bearer, awssecret, aws, email, creditcard, ipv6, ipv4.")
+    flag.StringVar(&flagRedactFields, "redactfields", "", "comma separated list of json field names to redact, i.e. password,authorization,cookie. Names are not case sensitive.")
+    flag.StringVar(&flagRedactPatterns, "redactpatterns", "", "read regular expressions to redact from this file, one per line.")
+    flag.StringVar(&flagRedactMode, "redactmode", "mask", "how to redact, 'mask' replaces with [REDACTED], 'hash' with a sha256 hash prefix of the value, 'remove' removes the value or json field.")
+    flag.StringVar(&flagTags, "tags", "", "comma separated list of name=value tags to add to every message, i.e. env=prod,team=publishing. Tags are sent as rfc5424 structured data.")
+    flag.StringVar(&flagTagEnv, "tagenv", "", "comma separated list of environment variables to add as tags to every message, i.e. POD_NAME,NAMESPACE, or name=VARIABLE to use a different tag name. Unset variables are skipped.")
+    flag.StringVar(&flagContainer, "container", "", "detect if running in a container and add the container id, image and pod name. 'tags' adds them as tags, 'hostname' prefixes the hostname with the pod name or short container id, 'all' does both.")
+    flag.StringVar(&flagSDID, "sdid", defaultSDID, "the rfc5424 structured data id used for tags.")
+    flag.DurationVar(&flagDedup, "dedup", 0, "collapse consecutive identical messages into one and a 'last message repeated N times' message, sent at the latest after this duration, i.e. 30s. Default is not to collapse messages.")
+    flag.BoolVar(&flagDedupFuzzy, "dedupfuzzy", false, "ignore a leading timestamp and any numbers when comparing messages for -dedup.")
+    flag.BoolVar(&flagDryRun, "dryrun", false, "don't send anything, print the messages read from input and if the filter rules keep or drop them.")
+    flag.StringVar(&flagListen, "listen", "", "receive syslog messages on these comma separated addresses instead of reading from a pipe, i.e. udp://:514,tcp://:514,unix:///dev/log. Changes need a restart.")
+    flag.StringVar(&flagInput, "input", "", "read from these comma separated named pipes and unix sockets instead of a pipe, i.e. fifo:///run/app.fifo?format=pino&appname=web,unix:///run/pipe2log.sock. The format and appname options override -logformat and -appname. Changes need a restart.")
+    flag.StringVar(&flagTail, "tail", "", "follow these comma separated files or globs like 'tail -F' instead of reading from a pipe, i.e. /var/log/app/*.log. Changes need a restart.")
+    flag.StringVar(&flagTailState, "tailstate", "", "save the offsets read of the -tail files in this file, so a restart resumes where it stopped.")
+    flag.StringVar(&flagConfigFile, "config", "", "read options from this file, one 'name = value' per line using the flag names. Options can also be set with PIPE2LOG_<NAME> environment variables. Command line flags take precedence over the environment, which takes precedence over the config file. Config file and environment are re-read on SIGHUP.")
 }
 
 
+
 func main() {
 
     var err error
@@ -595,54 +884,28 @@ func main() {
       os.Exit(0)
     }
 
-    if flagLogformat != "" && flagLogformat != "pm2json" && flagLogformat != "pm2log" && flagLogformat != "pino" {
-      log.Fatalf("Unsupported logformat: %s\n", flagLogformat)
-      os.Exit(1)
-    }
+    rememberCmdlineFlags()
+    err = loadConfig()
+    checkError(err)
+    err = checkConfig()
+    checkError(err)
+    scanFormat.Store(flagLogformat)
 
     // other args: flag.Args() should be passed as cmd args
 
-    logWriter.useConsole = true
-
-    if flagSyslogUri != "console" {
-        var u *url.URL
-
-        // decode syslog_uri
-        u, err = url.Parse(flagSyslogUri)
-        // logserver:514 or just logserver
-        if (u.Host == "" && (u.Path == "" && u.Scheme != "" || u.Path != "" && !strings.HasPrefix(u.Path,"/") && u.Scheme == "")) {
-            u, err = url.Parse("udp://"+flagSyslogUri)
-        }
-        // host w/o port number
-        if (u.Host != "" && strings.Index(u.Host,":") == -1) {
-            u.Host += ":514"
-        }
-        if flagSyslogUri == "localhost" {
-            u.Scheme = ""
-            u.Host = ""
-            u.Path = ""
-        }
-        // if using local log device we can't set/change hostname
-        localLogging = u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path,"/")
-
-        if err != nil {
-            log.Fatal(err)
-        }
-
-        syslog_facility := mapFacilityString(flagSyslogFacility)
-
-        logWriter.syslogWriter, err = syslog.Dial(u.Scheme, u.Host+u.Path, syslog.LOG_DEBUG|syslog_facility, flagSyslogAppname)
-        checkError(err)
+    rules, err = buildRuleSet()
+    checkError(err)
 
-        // set syslog format
-        if flagRFC3164 || localLogging {
-            logWriter.syslogWriter.SetFormatter(issuuRFC3164Formatter)
-        } else {
-            logWriter.syslogWriter.SetFormatter(issuuRFC5424Formatter)
-        }
-        logWriter.useConsole = false
+    if flagDryRun {
+        // only show what the filter rules do with the input
+        logWriter = dryRunWriter()
+        scanPipeLog()
+        return
     }
 
+    logWriter, err = openLogWriter(&logWriter)
+    checkError(err)
+
     logWriter.Info(appTag+" program started, version "+appVersion)
 
     // send some debug log - if running on a Mac anything not warning or worse are by default filtered out
@@ -660,6 +923,8 @@ func main() {
         scanCommand()
     }
 
+    flushRepeatedMessages(true)
+    sendRateLimitSummaries()
     logWriter.Info(appTagVersion+" program ended.")
     logWriter.Close()
 }
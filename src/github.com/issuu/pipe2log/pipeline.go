@@ -0,0 +1,104 @@
+package main
+
+import (
+    "fmt"
+)
+
+// ruleSet holds everything built from the configuration that is applied
+// to the parsed messages before they are sent. It is replaced as a whole
+// on a configuration reload.
+type ruleSet struct {
+    filters []*filterRule
+    redactor *redactor
+    limiter *rateLimiter
+    dedup *dedupFilter
+    tags []tag
+    // user defined line parsers by logformat name
+    parsers map[string]*lineParser
+    // finds the severity keyword in lines
+    severities *severityScanner
+    // prefixed to the hostname, i.e. the container id
+    hostnamePrefix string
+}
+
+var rules = &ruleSet{}
+
+func buildRuleSet() (*ruleSet, error) {
+    var err error
+    r := &ruleSet{}
+    if flagFilterFile != "" {
+        r.filters, err = loadFilterRules(flagFilterFile)
+        if err != nil {
+            return nil, err
+        }
+    }
+    if flagParserFile != "" {
+        r.parsers, err = loadLineParsers(flagParserFile)
+        if err != nil {
+            return nil, err
+        }
+    }
+    if !validLogformat(flagLogformat, r.parsers) {
+        return nil, fmt.Errorf("Unsupported logformat: %s", flagLogformat)
+    }
+    if !validLogformat(flagInnerFormat, r.parsers) || containerLogformat(flagInnerFormat) {
+        return nil, fmt.Errorf("Unsupported innerformat: %s", flagInnerFormat)
+    }
+    r.severities, err = newSeverityScanner()
+    if err != nil {
+        return nil, err
+    }
+    r.tags, err = parseTags(flagTags, flagTagEnv)
+    if err != nil {
+        return nil, err
+    }
+    if err = addContainerInfo(r); err != nil {
+        return nil, err
+    }
+    r.redactor, err = newRedactor()
+    if err != nil {
+        return nil, err
+    }
+    if !flagDryRun {
+        r.limiter, err = parseRateLimit(flagRateLimit)
+        if err != nil {
+            return nil, err
+        }
+        r.dedup = newDedupFilter()
+    }
+    return r, nil
+}
+
+// processMessage runs a parsed message through the rules and sends it
+func processMessage(m *logMessage) {
+    if m == nil {
+        return
+    }
+    if rule := matchFilterRules(rules.filters, m); rule != nil && rule.drop {
+        if flagDryRun {
+            fmt.Printf("DROP %s %s [%s:%d %s]\n", consoleSeverity[m.severity&0x07], m.text(), flagFilterFile, rule.line, rule.text)
+        }
+        return
+    }
+    if rules.redactor != nil {
+        rules.redactor.redact(m)
+    }
+    if rules.dedup != nil {
+        repeated, duplicate := rules.dedup.check(m)
+        if repeated != nil {
+            sendMessage(repeated)
+        }
+        if duplicate {
+            return
+        }
+    }
+    sendMessage(m)
+}
+
+// sendMessage applies the global rate limits and sends m
+func sendMessage(m *logMessage) {
+    if rules.limiter != nil && !rules.limiter.allow(m.severity) {
+        return
+    }
+    logWriter.write(m)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+// Rate limits are token buckets, one bucket per severity. A limit is given
+// as a list of '[severity=]rate[/unit][:burst]' separated by a comma or
+// semicolon, i.e.
+//   info=100/s:500;debug=10/s
+// The unit is s, m or h and defaults to per second, the burst defaults to
+// the rate. A limit without severity applies to every severity not given
+// explicitly. Messages over the limit are counted and a summary is sent
+// every -ratesummary, i.e. "suppressed 1234 info messages in last 10s".
+
+var flagRateLimit string
+var flagRateSummary time.Duration
+
+var severityNames = [...]string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+type tokenBucket struct {
+    rate float64 // tokens per second
+    burst float64
+    tokens float64
+    last time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+    b.tokens += now.Sub(b.last).Seconds() * b.rate
+    if b.tokens > b.burst {
+        b.tokens = b.burst
+    }
+    b.last = now
+    if b.tokens < 1 {
+        return false
+    }
+    b.tokens--
+    return true
+}
+
+type rateLimiter struct {
+    // nil buckets are unlimited
+    buckets [8]*tokenBucket
+    suppressed [8]int
+    since time.Time
+}
+
+var rateLimit_re = regexp.MustCompile(`^(?:([a-zA-Z*]+)=)?([0-9.]+)(?:/([smh]))?(?::([0-9]+))?$`)
+
+// parseRateLimit returns nil if there are no limits given
+func parseRateLimit(spec string) (*rateLimiter, error) {
+    var limits [8]*tokenBucket
+    var fallback *tokenBucket
+    given := false
+    now := time.Now()
+    for _, item := range strings.FieldsFunc(spec, func(c rune) bool { return c == ',' || c == ';' }) {
+        item = strings.TrimSpace(item)
+        rs := rateLimit_re.FindStringSubmatch(item)
+        if rs == nil {
+            return nil, fmt.Errorf("invalid rate limit '%s', use [severity=]rate[/s|m|h][:burst]", item)
+        }
+        rate, err := strconv.ParseFloat(rs[2], 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid rate limit '%s': %s", item, err)
+        }
+        switch rs[3] {
+        case "m":
+            rate /= 60
+        case "h":
+            rate /= 3600
+        }
+        burst := rate
+        if rs[4] != "" {
+            burst, _ = strconv.ParseFloat(rs[4], 64)
+        }
+        if burst < 1 {
+            burst = 1
+        }
+        b := &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+        if rs[1] == "" || rs[1] == "*" {
+            fallback = b
+        } else {
+            p, err := mapSeverityString(rs[1])
+            if err != nil {
+                return nil, err
+            }
+            limits[p] = b
+        }
+        given = true
+    }
+    if !given {
+        return nil, nil
+    }
+    r := &rateLimiter{since: now}
+    for p := range r.buckets {
+        switch {
+        case limits[p] != nil:
+            r.buckets[p] = limits[p]
+        case fallback != nil:
+            b := *fallback
+            r.buckets[p] = &b
+        }
+    }
+    return r, nil
+}
+
+func (r *rateLimiter) allow(p syslog.Priority) bool {
+    p &= 0x07
+    if r.buckets[p] == nil || r.buckets[p].allow(time.Now()) {
+        return true
+    }
+    r.suppressed[p]++
+    return false
+}
+
+// summary returns a message per severity with suppressed messages since
+// the last summary
+func (r *rateLimiter) summary() []*logMessage {
+    var summary []*logMessage
+    now := time.Now()
+    elapsed := now.Sub(r.since)
+    elapsed -= elapsed % time.Second
+    for p, n := range r.suppressed {
+        if n > 0 {
+            msg := fmt.Sprintf("%s suppressed %d %s messages in last %s", appTagVersion, n, severityNames[p], elapsed)
+            summary = append(summary, &logMessage{severity: syslog.Priority(p), msg: msg})
+        }
+        r.suppressed[p] = 0
+    }
+    r.since = now
+    return summary
+}
+
+// sendRateLimitSummaries sends the summaries of both the global and the
+// per destination rate limits.
+func sendRateLimitSummaries() {
+    if rules.limiter != nil {
+        // a summary isn't counted against the limits of the destinations
+        for _, m := range rules.limiter.summary() {
+            logWriter.send(m, false)
+        }
+    }
+    for _, d := range logWriter.destinations {
+        if d.limiter != nil {
+            for _, m := range d.limiter.summary() {
+                m.setDefaults()
+                d.sink.write(m)
+            }
+        }
+    }
+}
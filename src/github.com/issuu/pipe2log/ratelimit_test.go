@@ -0,0 +1,124 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestTokenBucket(t *testing.T) {
+    now := time.Now()
+    b := &tokenBucket{rate: 2, burst: 3, tokens: 3, last: now}
+    allowed := 0
+    for i := 0; i < 10; i++ {
+        if b.allow(now) {
+            allowed++
+        }
+    }
+    if allowed != 3 {
+        t.Errorf("got %d allowed at once, expected the burst of 3", allowed)
+    }
+    // 2 tokens per second
+    if !b.allow(now.Add(500*time.Millisecond)) || b.allow(now.Add(500*time.Millisecond)) {
+        t.Errorf("expected one token after 500ms")
+    }
+    // never more than the burst
+    allowed = 0
+    for i := 0; i < 10; i++ {
+        if b.allow(now.Add(time.Hour)) {
+            allowed++
+        }
+    }
+    if allowed != 3 {
+        t.Errorf("got %d allowed after an hour, expected the burst of 3", allowed)
+    }
+}
+
+func TestParseRateLimit(t *testing.T) {
+    tests := []struct {
+        spec string
+        // rate and burst per severity, 0 for unlimited
+        rates [8]float64
+        bursts [8]float64
+    }{
+        {"info=100/s:500;debug=10/s", [8]float64{0, 0, 0, 0, 0, 0, 100, 10}, [8]float64{0, 0, 0, 0, 0, 0, 500, 10}},
+        {"60/m, err=2", [8]float64{1, 1, 1, 2, 1, 1, 1, 1}, [8]float64{1, 1, 1, 2, 1, 1, 1, 1}},
+        {"*=3600/h:10", [8]float64{1, 1, 1, 1, 1, 1, 1, 1}, [8]float64{10, 10, 10, 10, 10, 10, 10, 10}},
+        {"warning=0.5", [8]float64{0, 0, 0, 0, 0.5, 0, 0, 0}, [8]float64{0, 0, 0, 0, 1, 0, 0, 0}},
+    }
+    for _, test := range tests {
+        r, err := parseRateLimit(test.spec)
+        if err != nil {
+            t.Errorf("%s: %s", test.spec, err)
+            continue
+        }
+        for p, b := range r.buckets {
+            switch {
+            case test.rates[p] == 0 && b != nil:
+                t.Errorf("%s: %s is limited", test.spec, severityNames[p])
+            case test.rates[p] != 0 && (b == nil || b.rate != test.rates[p] || b.burst != test.bursts[p]):
+                t.Errorf("%s: got %+v for %s, expected %v:%v", test.spec, b, severityNames[p], test.rates[p], test.bursts[p])
+            }
+        }
+    }
+    // the fallback bucket is not shared between severities
+    r, _ := parseRateLimit("1")
+    if r.buckets[syslog.LOG_INFO] == r.buckets[syslog.LOG_DEBUG] {
+        t.Errorf("severities share a bucket")
+    }
+    if r, err := parseRateLimit(""); r != nil || err != nil {
+        t.Errorf("got %v %v without limits", r, err)
+    }
+    for _, spec := range []string{"fast", "info=10/d", "verbose=10", "info=-1"} {
+        if _, err := parseRateLimit(spec); err == nil {
+            t.Errorf("%s: expected an error", spec)
+        }
+    }
+}
+
+func TestRateLimiterSummary(t *testing.T) {
+    r, _ := parseRateLimit("info=1:1")
+    r.since = time.Now().Add(-10 * time.Second)
+    for i := 0; i < 4; i++ {
+        r.allow(syslog.LOG_INFO)
+        r.allow(syslog.LOG_DEBUG | syslog.LOG_LOCAL4)
+    }
+    summary := r.summary()
+    if len(summary) != 1 || summary[0].severity != syslog.LOG_INFO || !strings.HasSuffix(summary[0].msg, "suppressed 3 info messages in last 10s") {
+        t.Fatalf("got %v", summary)
+    }
+    if summary := r.summary(); len(summary) != 0 {
+        t.Errorf("got %v after the counts were reset", summary)
+    }
+}
+
+func TestSendRateLimitSummaries(t *testing.T) {
+    sink, restore := useTestPipeline(t)
+    defer restore()
+    global, _ := parseRateLimit("info=1:1")
+    limited, _ := parseRateLimit("1:1")
+    rules.limiter = global
+    logWriter.destinations[0].limiter = limited
+    for i := 0; i < 3; i++ {
+        sendMessage(&logMessage{severity: syslog.LOG_INFO, msg: "hello"})
+    }
+    sendMessage(&logMessage{severity: syslog.LOG_ERR, msg: "failed"})
+    sendMessage(&logMessage{severity: syslog.LOG_ERR, msg: "failed"})
+    sendRateLimitSummaries()
+
+    var msgs []string
+    for _, m := range sink.messages {
+        msgs = append(msgs, m.msg)
+        if m.time.IsZero() {
+            t.Errorf("%s: no time", m.msg)
+        }
+    }
+    // the global summary isn't limited by the destination, which has its
+    // own summary for the error
+    if len(msgs) != 4 || msgs[0] != "hello" || msgs[1] != "failed" || !strings.HasSuffix(msgs[2], "suppressed 2 info messages in last 0s") ||
+        !strings.HasSuffix(msgs[3], "suppressed 1 err messages in last 0s") {
+        t.Errorf("got %q", msgs)
+    }
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "regexp"
+    "strings"
+)
+
+// Redaction replaces secrets and personal data in the message text and in
+// the json fields before anything is sent. Built in detectors are enabled
+// with -redact, json fields are redacted by name with -redactfields and
+// user defined regular expressions are read from -redactpatterns.
+
+var flagRedact string
+var flagRedactFields string
+var flagRedactPatterns string
+var flagRedactMode string
+
+type redactDetector struct {
+    re *regexp.Regexp
+    // the first submatch is kept, i.e. the 'Bearer ' in front of a token
+    keepPrefix bool
+    // optional check of a match, to avoid false positives
+    valid func(string) bool
+}
+
+var redactDetectors = map[string]*redactDetector{
+    "email": &redactDetector{re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+    "creditcard": &redactDetector{re: regexp.MustCompile(`\b(?:[0-9][ -]?){12,18}[0-9]\b`), valid: luhnValid},
+    "bearer": &redactDetector{re: regexp.MustCompile(`(?i)(\bbearer[ ]+)([A-Za-z0-9\-._~+/]+=*)`), keepPrefix: true},
+    "aws": &redactDetector{re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+    "awssecret": &redactDetector{re: regexp.MustCompile(`(?i)(aws_?secret_?access_?key["']?[ ]*[=:][ ]*["']?)([A-Za-z0-9/+=]{40})`), keepPrefix: true},
+    "jwt": &redactDetector{re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+    "ipv4": &redactDetector{re: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\b`)},
+    "ipv6": &redactDetector{re: regexp.MustCompile(`(^|[^0-9A-Za-z:])([0-9A-Fa-f]*:[0-9A-Fa-f.]*:[0-9A-Fa-f:.]*)`), keepPrefix: true, valid: ipv6Valid},
+}
+
+// the order detectors are applied in, tokens before the parts they contain
+var redactDetectorOrder = []string{"jwt", "bearer", "awssecret", "aws", "email", "creditcard", "ipv6", "ipv4"}
+
+// luhnValid checks the credit card number checksum
+func luhnValid(s string) bool {
+    sum := 0
+    digits := 0
+    double := false
+    for i := len(s) - 1; i >= 0; i-- {
+        c := s[i]
+        if c < '0' || c > '9' {
+            continue
+        }
+        d := int(c - '0')
+        if double {
+            d *= 2
+            if d > 9 {
+                d -= 9
+            }
+        }
+        sum += d
+        digits++
+        double = !double
+    }
+    return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+func ipv6Valid(s string) bool {
+    ip := net.ParseIP(s)
+    return ip != nil && ip.To4() == nil && strings.IndexAny(s, "0123456789abcdefABCDEF") >= 0
+}
+
+type redactor struct {
+    detectors []*redactDetector
+    fields map[string]bool
+    mode string
+}
+
+func splitList(list string) []string {
+    var items []string
+    for _, item := range strings.Split(list, ",") {
+        item = strings.TrimSpace(item)
+        if item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
+
+func loadRedactPatterns(path string) ([]*redactDetector, error) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var detectors []*redactDetector
+    s := bufio.NewScanner(bytes.NewReader(content))
+    lineno := 0
+    for s.Scan() {
+        lineno++
+        line := strings.TrimSpace(s.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        re, err := regexp.Compile(line)
+        if err != nil {
+            return nil, fmt.Errorf("%s:%d %s", path, lineno, err)
+        }
+        detectors = append(detectors, &redactDetector{re: re})
+    }
+    return detectors, s.Err()
+}
+
+// newRedactor returns nil if nothing is to be redacted
+func newRedactor() (*redactor, error) {
+    r := &redactor{fields: make(map[string]bool), mode: flagRedactMode}
+    switch r.mode {
+    case "mask", "hash", "remove":
+    default:
+        return nil, fmt.Errorf("Unsupported redact mode '%s', mask, hash and remove are supported.", r.mode)
+    }
+
+    enabled := make(map[string]bool)
+    for _, name := range splitList(flagRedact) {
+        if name == "all" {
+            for n := range redactDetectors {
+                enabled[n] = true
+            }
+            continue
+        }
+        if redactDetectors[name] == nil {
+            return nil, fmt.Errorf("unknown redact detector '%s', valid detectors are: all, %s", name, strings.Join(redactDetectorOrder, ", "))
+        }
+        enabled[name] = true
+    }
+    for _, name := range redactDetectorOrder {
+        if enabled[name] {
+            r.detectors = append(r.detectors, redactDetectors[name])
+        }
+    }
+    if flagRedactPatterns != "" {
+        patterns, err := loadRedactPatterns(flagRedactPatterns)
+        if err != nil {
+            return nil, err
+        }
+        r.detectors = append(r.detectors, patterns...)
+    }
+    for _, name := range splitList(flagRedactFields) {
+        r.fields[strings.ToLower(name)] = true
+    }
+    if len(r.detectors) == 0 && len(r.fields) == 0 {
+        return nil, nil
+    }
+    return r, nil
+}
+
+// replacement for a redacted value
+func (r *redactor) replace(value string) string {
+    switch r.mode {
+    case "hash":
+        sum := sha256.Sum256([]byte(value))
+        return "[hash:" + hex.EncodeToString(sum[:8]) + "]"
+    case "remove":
+        return ""
+    }
+    return "[REDACTED]"
+}
+
+func (r *redactor) redactString(s string) string {
+    for _, d := range r.detectors {
+        s = d.re.ReplaceAllStringFunc(s, func(match string) string {
+            prefix, value := "", match
+            if d.keepPrefix {
+                rs := d.re.FindStringSubmatch(match)
+                prefix, value = rs[1], rs[2]
+            }
+            if d.valid != nil && !d.valid(value) {
+                return match
+            }
+            return prefix + r.replace(value)
+        })
+    }
+    return s
+}
+
+func (r *redactor) redactValue(value interface{}) interface{} {
+    switch v := value.(type) {
+    case string:
+        return r.redactString(v)
+    case map[string]interface{}:
+        r.redactFields(v)
+    case []interface{}:
+        for i := range v {
+            v[i] = r.redactValue(v[i])
+        }
+    }
+    return value
+}
+
+// redactFields redacts fields by name, and values by the detectors
+func (r *redactor) redactFields(fields map[string]interface{}) {
+    for name, value := range fields {
+        if r.fields[strings.ToLower(name)] {
+            if r.mode == "remove" {
+                delete(fields, name)
+            } else {
+                fields[name] = r.replace(fieldString(value))
+            }
+            continue
+        }
+        fields[name] = r.redactValue(value)
+    }
+}
+
+// redactParam redacts an sd parameter value, like a field by name and
+// with the detectors
+func (r *redactor) redactParam(name, value string) string {
+    if r.fields[strings.ToLower(name)] {
+        return r.replace(value)
+    }
+    return r.redactString(value)
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// redactStructuredData redacts the parameter values of rfc5424 structured
+// data, [id name="value" ...], where ", \ and ] are escaped with a
+// backslash in values.
+func (r *redactor) redactStructuredData(sd string) string {
+    var out bytes.Buffer
+    inElement := false
+    for i := 0; i < len(sd); i++ {
+        c := sd[i]
+        switch {
+        case !inElement:
+            inElement = c == '['
+            out.WriteByte(c)
+        case c == ']':
+            inElement = false
+            out.WriteByte(c)
+        case c == '"':
+            // the parameter name is in front of the =
+            before := strings.TrimSuffix(out.String(), "=")
+            name := before[strings.LastIndex(before, " ")+1:]
+            start := i + 1
+            var value bytes.Buffer
+            for i++; i < len(sd) && sd[i] != '"'; i++ {
+                if sd[i] == '\\' && i+1 < len(sd) && strings.IndexByte(`"\]`, sd[i+1]) >= 0 {
+                    i++
+                }
+                value.WriteByte(sd[i])
+            }
+            redacted := r.redactParam(name, value.String())
+            out.WriteByte('"')
+            if redacted == value.String() {
+                out.WriteString(sd[start:i])
+            } else {
+                out.WriteString(sdEscaper.Replace(redacted))
+            }
+            out.WriteByte('"')
+        default:
+            out.WriteByte(c)
+        }
+    }
+    return out.String()
+}
+
+func (r *redactor) redact(m *logMessage) {
+    m.msg = r.redactString(m.msg)
+    if m.fields != nil {
+        r.redactFields(m.fields)
+    }
+    // received by the syslog listener
+    if m.structuredData != "" {
+        m.structuredData = r.redactStructuredData(m.structuredData)
+    }
+}
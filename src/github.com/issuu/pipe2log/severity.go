@@ -0,0 +1,151 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+
+    syslog "github.com/issuu/srslog"
+)
+
+// Lines are scanned for a severity keyword at the start, after an
+// optional numeric date, i.e. 'ERROR ...', '[WARN] ...' or
+// '2019-01-02 15:04:05 INFO: ...'. The keyword is removed from the
+// message. More keywords can be added with -severityaliases, i.e.
+//   -severityaliases 'W=warning,E=err,FAILED=err'
+// a single letter keyword only matches followed by a colon or between
+// brackets, 'W: ...' or '[W] ...'. Keywords are case sensitive, an alias
+// keeps its case, unless -severityignorecase is given. With -severitysearch the keyword is also
+// looked for as a word in the first characters of the line, the message
+// is then kept as it is. Lines without a keyword get -defaultseverity.
+
+var flagSeverityAliases string
+var flagSeverityIgnoreCase bool
+var flagSeveritySearch int
+var flagDefaultSeverity string
+
+// the keywords known without aliases
+var severityKeywords = map[string]syslog.Priority{
+    "TRACE": syslog.LOG_DEBUG,
+    "DEBUG": syslog.LOG_DEBUG,
+    "INFO": syslog.LOG_INFO,
+    "NOTICE": syslog.LOG_NOTICE,
+    "WARN": syslog.LOG_WARNING,
+    "WARNING": syslog.LOG_WARNING,
+    "ERR": syslog.LOG_ERR,
+    "ERROR": syslog.LOG_ERR,
+    "SEVERE": syslog.LOG_ERR,
+    "CRIT": syslog.LOG_CRIT,
+    "CRITICAL": syslog.LOG_CRIT,
+    "FATAL": syslog.LOG_CRIT,
+    "PANIC": syslog.LOG_CRIT,
+    "ALERT": syslog.LOG_ALERT,
+    "EMERG": syslog.LOG_EMERG,
+    "EMERGENCY": syslog.LOG_EMERG,
+}
+
+// longestFirst sorts keywords by length, longest first
+type longestFirst []string
+
+func (k longestFirst) Len() int { return len(k) }
+func (k longestFirst) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k longestFirst) Less(i, j int) bool {
+    if len(k[i]) != len(k[j]) {
+        return len(k[i]) > len(k[j])
+    }
+    return k[i] < k[j]
+}
+
+type severityScanner struct {
+    // the keywords, uppercase when the case is ignored
+    keywords map[string]syslog.Priority
+    ignoreCase bool
+    // the keyword at the start, after an optional numeric date
+    start *regexp.Regexp
+    // the keyword as a word anywhere
+    anywhere *regexp.Regexp
+    searchLen int
+    defaultSeverity syslog.Priority
+}
+
+func newSeverityScanner() (*severityScanner, error) {
+    s := &severityScanner{keywords: make(map[string]syslog.Priority), ignoreCase: flagSeverityIgnoreCase, searchLen: flagSeveritySearch}
+    for keyword, severity := range severityKeywords {
+        s.keywords[keyword] = severity
+    }
+    aliases, err := parseSeverityTable(flagSeverityAliases)
+    if err != nil {
+        return nil, fmt.Errorf("invalid severityaliases: %s", err)
+    }
+    for keyword, severity := range aliases {
+        if s.ignoreCase {
+            keyword = strings.ToUpper(keyword)
+        }
+        s.keywords[keyword] = severity
+    }
+    s.defaultSeverity, err = mapSeverityString(flagDefaultSeverity)
+    if err != nil {
+        return nil, fmt.Errorf("invalid defaultseverity: %s", err)
+    }
+
+    // the longest keyword first, so WARNING isn't matched as WARN
+    var keywords []string
+    for keyword := range s.keywords {
+        keywords = append(keywords, keyword)
+    }
+    sort.Sort(longestFirst(keywords))
+    for i, keyword := range keywords {
+        keywords[i] = regexp.QuoteMeta(keyword)
+    }
+    flags := ""
+    if s.ignoreCase {
+        flags = "(?i)"
+    }
+    alternatives := strings.Join(keywords, "|")
+    s.start = regexp.MustCompile(flags + `^[ ]*([0-9- /:.]*)[[]?(` + alternatives + `)[]]?[ :](.*)$`)
+    s.anywhere = regexp.MustCompile(flags + `(?:^|[^\pL\pN_])[[]?(` + alternatives + `)[]]?(?:[ :]|$)`)
+    return s, nil
+}
+
+// severity returns the severity of the keyword at data[start:end], a
+// single letter needs a colon after it or brackets around it.
+func (s *severityScanner) severity(data []byte, start, end int) (syslog.Priority, bool) {
+    if end-start == 1 {
+        colon := end < len(data) && data[end] == ':'
+        brackets := start > 0 && data[start-1] == '[' && end < len(data) && data[end] == ']'
+        if !colon && !brackets {
+            return 0, false
+        }
+    }
+    keyword := string(data[start:end])
+    if s.ignoreCase {
+        keyword = strings.ToUpper(keyword)
+    }
+    severity, ok := s.keywords[keyword]
+    return severity, ok
+}
+
+// scan finds the severity of a line, found is false when the default
+// severity is used.
+func (s *severityScanner) scan(data []byte) (lm *logMessage, found bool) {
+    if rs := s.start.FindSubmatchIndex(data); rs != nil {
+        if severity, ok := s.severity(data, rs[4], rs[5]); ok {
+            msg := string(data[rs[2]:rs[3]]) + string(data[rs[6]:rs[7]])
+            return &logMessage{severity: severity, msg: msg}, true
+        }
+    }
+    if s.searchLen > 0 {
+        // a single letter without a colon or brackets doesn't count, a
+        // later keyword can
+        for _, rs := range s.anywhere.FindAllSubmatchIndex(data, -1) {
+            if rs[2] >= s.searchLen {
+                break
+            }
+            if severity, ok := s.severity(data, rs[2], rs[3]); ok {
+                return &logMessage{severity: severity, msg: string(data)}, true
+            }
+        }
+    }
+    return &logMessage{severity: s.defaultSeverity, msg: string(data)}, false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "testing"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func newTestSeverityScanner(t *testing.T, aliases string, ignoreCase bool, search int) *severityScanner {
+    defer func(aliases string, ignoreCase bool, search int, defaultSeverity string) {
+        flagSeverityAliases, flagSeverityIgnoreCase, flagSeveritySearch, flagDefaultSeverity = aliases, ignoreCase, search, defaultSeverity
+    }(flagSeverityAliases, flagSeverityIgnoreCase, flagSeveritySearch, flagDefaultSeverity)
+    flagSeverityAliases, flagSeverityIgnoreCase, flagSeveritySearch, flagDefaultSeverity = aliases, ignoreCase, search, "notice"
+    s, err := newSeverityScanner()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return s
+}
+
+func TestSeverityScan(t *testing.T) {
+    tests := []struct {
+        aliases string
+        ignoreCase bool
+        search int
+        line string
+        severity syslog.Priority
+        msg string
+        found bool
+    }{
+        {"", false, 0, "ERROR disk full", syslog.LOG_ERR, "disk full", true},
+        {"", false, 0, "[WARN] disk almost full", syslog.LOG_WARNING, "disk almost full", true},
+        {"", false, 0, "WARNING: disk almost full", syslog.LOG_WARNING, " disk almost full", true},
+        {"", false, 0, "2019-01-02 15:04:05 INFO listening", syslog.LOG_INFO, "2019-01-02 15:04:05 listening", true},
+        {"", false, 0, "error: lowercase", syslog.LOG_NOTICE, "error: lowercase", false},
+        {"", true, 0, "error lowercase", syslog.LOG_ERR, "lowercase", true},
+        {"", false, 0, "ERRORS are not a keyword", syslog.LOG_NOTICE, "ERRORS are not a keyword", false},
+        {"W=warning", false, 0, "W: single letter", syslog.LOG_WARNING, " single letter", true},
+        {"W=warning", false, 0, "[W] single letter", syslog.LOG_WARNING, "single letter", true},
+        {"W=warning", false, 0, "W single letter", syslog.LOG_NOTICE, "W single letter", false},
+        // an alias keeps its case unless the case is ignored
+        {"failed=err", false, 0, "failed to connect", syslog.LOG_ERR, "to connect", true},
+        {"failed=err", false, 0, "FAILED to connect", syslog.LOG_NOTICE, "FAILED to connect", false},
+        {"failed=err", true, 0, "FAILED to connect", syslog.LOG_ERR, "to connect", true},
+        {"", false, 20, "connection to db: ERROR timeout", syslog.LOG_ERR, "connection to db: ERROR timeout", true},
+        {"", false, 10, "connection to db: ERROR timeout", syslog.LOG_NOTICE, "connection to db: ERROR timeout", false},
+        // the first word matched doesn't count, a later one does
+        {"A=alert", false, 20, "got A reply, ERROR: 5", syslog.LOG_ERR, "got A reply, ERROR: 5", true},
+        {"A=alert", false, 10, "got A reply, ERROR: 5", syslog.LOG_NOTICE, "got A reply, ERROR: 5", false},
+    }
+    for _, test := range tests {
+        s := newTestSeverityScanner(t, test.aliases, test.ignoreCase, test.search)
+        lm, found := s.scan([]byte(test.line))
+        if lm.severity != test.severity || lm.msg != test.msg || found != test.found {
+            t.Errorf("%q: got %d %q %v, expected %d %q %v", test.line, lm.severity, lm.msg, found, test.severity, test.msg, test.found)
+        }
+    }
+}
+
+func TestNewSeverityScannerErrors(t *testing.T) {
+    defer func(aliases, defaultSeverity string) {
+        flagSeverityAliases, flagDefaultSeverity = aliases, defaultSeverity
+    }(flagSeverityAliases, flagDefaultSeverity)
+    flagDefaultSeverity = "info"
+    for _, aliases := range []string{"W", "=warning", "W=sometimes"} {
+        flagSeverityAliases = aliases
+        if _, err := newSeverityScanner(); err == nil {
+            t.Errorf("%s: expected an error", aliases)
+        }
+    }
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/json"
+    "fmt"
+    url "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// splunkSink posts batches of events to the Splunk HTTP Event Collector,
+// i.e.
+//   splunk+https://splunk:8088?token=...&index=app&sourcetype=pipe2log
+// The message is the event, the severity, appname, tags and parsed json
+// fields are indexed fields. With ack the batch is only done when the
+// indexers have acknowledged it, otherwise it is sent again.
+type splunkSink struct {
+    source string
+    sourcetype string
+    index string
+    ack bool
+    ackTimeout time.Duration
+    poster *httpPoster
+    batcher *batcher
+}
+
+const splunkScheme = "splunk+"
+const splunkEventPath = "/services/collector/event"
+const splunkAckPath = "/services/collector/ack"
+
+// how often the indexer acknowledgement is polled
+var splunkAckInterval = time.Second
+
+// the options of the splunk destination
+var splunkOptionNames = []string{"token", "source", "sourcetype", "index", "ack", "acktimeout", "channel"}
+
+func openSplunkSink(spec, uri string, options url.Values) (logSink, error) {
+    if err := checkOptions(spec, options, append(append(splunkOptionNames, batchOptionNames...), httpOptionNames...)...); err != nil {
+        return nil, err
+    }
+    uri = strings.TrimPrefix(uri, splunkScheme)
+    if !strings.HasPrefix(uri, "http:") && !strings.HasPrefix(uri, "https:") {
+        return nil, fmt.Errorf("invalid splunk destination '%s', use splunk+http(s)://host:8088", spec)
+    }
+    s := &splunkSink{
+        source: options.Get("source"),
+        sourcetype: options.Get("sourcetype"),
+        index: options.Get("index"),
+        ackTimeout: time.Minute,
+    }
+    token := options.Get("token")
+    if token == "" {
+        return nil, fmt.Errorf("missing token for destination '%s'", spec)
+    }
+    var err error
+    if v := options.Get("ack"); v != "" {
+        if s.ack, err = strconv.ParseBool(v); err != nil {
+            return nil, fmt.Errorf("invalid ack '%s' for destination '%s'", v, spec)
+        }
+    }
+    if v := options.Get("acktimeout"); v != "" {
+        if s.ackTimeout, err = time.ParseDuration(v); err != nil {
+            return nil, fmt.Errorf("invalid acktimeout '%s' for destination '%s'", v, spec)
+        }
+    }
+    batchOptions, err := parseBatchOptions(spec, options)
+    if err != nil {
+        return nil, err
+    }
+    s.poster, err = newHTTPPoster(spec, uri, options, splunkOptionNames...)
+    if err != nil {
+        return nil, err
+    }
+    s.poster.headers.Set("Authorization", "Splunk "+token)
+    // acknowledgement needs a channel, any guid
+    if channel := options.Get("channel"); channel != "" || s.ack {
+        if channel == "" {
+            if channel, err = randomGUID(); err != nil {
+                return nil, err
+            }
+        }
+        s.poster.headers.Set("X-Splunk-Request-Channel", channel)
+    }
+    s.batcher = newBatcher(uri, batchOptions, s.send)
+    return s, nil
+}
+
+func randomGUID() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    b[6] = b[6]&0x0f | 0x40
+    b[8] = b[8]&0x3f | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+type splunkEvent struct {
+    Time float64 `json:"time"`
+    Host string `json:"host"`
+    Source string `json:"source"`
+    Sourcetype string `json:"sourcetype,omitempty"`
+    Index string `json:"index,omitempty"`
+    Event string `json:"event"`
+    Fields map[string]interface{} `json:"fields"`
+}
+
+// splunkFields flattens the json fields, indexed fields are strings or
+// arrays of strings
+func splunkFields(fields map[string]interface{}, prefix string, values map[string]interface{}) {
+    for name, value := range values {
+        switch v := value.(type) {
+        case map[string]interface{}:
+            splunkFields(fields, prefix+name+".", v)
+        case []interface{}:
+            var items []string
+            for _, item := range v {
+                items = append(items, fieldString(item))
+            }
+            fields[prefix+name] = items
+        default:
+            fields[prefix+name] = fieldString(v)
+        }
+    }
+}
+
+func (s *splunkSink) event(m *logMessage) splunkEvent {
+    e := splunkEvent{
+        Time: float64(m.time.UnixNano()/int64(time.Millisecond)) / 1000,
+        Host: m.host(),
+        Source: s.source,
+        Sourcetype: s.sourcetype,
+        Index: s.index,
+        Event: m.msg,
+        Fields: make(map[string]interface{}),
+    }
+    if e.Source == "" {
+        e.Source = m.app()
+    }
+    splunkFields(e.Fields, "", m.fields)
+    for _, t := range m.tags {
+        e.Fields[t.name] = t.value
+    }
+    e.Fields["severity"] = severityNames[m.severity&0x07]
+    e.Fields["appname"] = m.app()
+    return e
+}
+
+type splunkResponse struct {
+    Text string `json:"text"`
+    Code int `json:"code"`
+    AckID *int64 `json:"ackId"`
+}
+
+func (s *splunkSink) send(batch []*logMessage) error {
+    var body bytes.Buffer
+    for _, m := range batch {
+        _byteArray, err := json.Marshal(s.event(m))
+        if err != nil {
+            continue
+        }
+        body.Write(_byteArray)
+        body.WriteByte('\n')
+    }
+    response, err := s.poster.post(splunkEventPath, body.Bytes(), "application/json")
+    if err != nil || !s.ack {
+        return err
+    }
+    var result splunkResponse
+    if err = json.Unmarshal(response, &result); err != nil || result.AckID == nil {
+        return permanentError{fmt.Errorf("no ackId in response '%s', is indexer acknowledgement enabled for the token?", bytes.TrimSpace(response))}
+    }
+    return s.waitForAck(*result.AckID)
+}
+
+// waitForAck polls until the batch is indexed
+func (s *splunkSink) waitForAck(id int64) error {
+    request, _ := json.Marshal(map[string][]int64{"acks": {id}})
+    deadline := time.Now().Add(s.ackTimeout)
+    for time.Now().Before(deadline) {
+        time.Sleep(splunkAckInterval)
+        response, err := s.poster.post(splunkAckPath, request, "application/json")
+        if err != nil {
+            if _, ok := err.(permanentError); ok {
+                return err
+            }
+            continue
+        }
+        var result struct {
+            Acks map[string]bool `json:"acks"`
+        }
+        if err = json.Unmarshal(response, &result); err == nil && result.Acks[strconv.FormatInt(id, 10)] {
+            return nil
+        }
+    }
+    return fmt.Errorf("no acknowledgement for ackId %d within %s", id, s.ackTimeout)
+}
+
+func (s *splunkSink) write(m *logMessage) error {
+    s.batcher.add(m)
+    return nil
+}
+
+func (s *splunkSink) close() error {
+    s.batcher.close()
+    return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestSplunkEvent(t *testing.T) {
+    tests := []struct {
+        sink *splunkSink
+        m *logMessage
+        want string
+    }{
+        {
+            &splunkSink{},
+            &logMessage{severity: syslog.LOG_ERR, msg: "failed", time: testTime, hostname: "web1", appname: "app"},
+            `{"time":1546441445.123,"host":"web1","source":"app","event":"failed","fields":{"appname":"app","severity":"err"}}`,
+        },
+        {
+            &splunkSink{source: "pipe2log", sourcetype: "json", index: "main"},
+            &logMessage{severity: syslog.LOG_INFO, msg: "request", time: testTime, hostname: "web1", appname: "app",
+                fields: map[string]interface{}{"http": map[string]interface{}{"status": float64(200)}, "ids": []interface{}{"a", float64(1)}},
+                tags: []tag{{"env", "prod"}}},
+            `{"time":1546441445.123,"host":"web1","source":"pipe2log","sourcetype":"json","index":"main","event":"request","fields":{"appname":"app","env":"prod","http.status":"200","ids":["a","1"],"severity":"info"}}`,
+        },
+    }
+    for _, test := range tests {
+        got, err := json.Marshal(test.sink.event(test.m))
+        if err != nil {
+            t.Errorf("%s: %s", test.m.msg, err)
+        } else if string(got) != test.want {
+            t.Errorf("got %s, expected %s", got, test.want)
+        }
+    }
+}
+
+func TestSplunkSinkAck(t *testing.T) {
+    defer func(interval time.Duration) { splunkAckInterval = interval }(splunkAckInterval)
+    splunkAckInterval = 10 * time.Millisecond
+
+    tests := []struct {
+        name string
+        // the ack polls answered before the batch is acknowledged
+        pending int
+        acktimeout string
+        events int
+    }{
+        {"acknowledged", 2, "1s", 1},
+        // not acknowledged in time, sent again
+        {"resent", 1000, "50ms", 2},
+    }
+    for _, test := range tests {
+        polls := 0
+        server := newTestServer(func(r *http.Request, body []byte) (int, string) {
+            if r.URL.Path == splunkAckPath {
+                polls++
+                if polls <= test.pending {
+                    return http.StatusOK, `{"acks":{"7":false}}`
+                }
+                return http.StatusOK, `{"acks":{"7":true}}`
+            }
+            return http.StatusOK, `{"text":"Success","code":0,"ackId":7}`
+        })
+        sink := openTestSink(t, openSplunkSink, "splunk+"+server.URL+"?token=secret&ack=true&retries=1&acktimeout="+test.acktimeout)
+        sink.write(&logMessage{severity: syslog.LOG_INFO, msg: "one", time: testTime, hostname: "web1", appname: "app"})
+        sink.close()
+        server.Close()
+
+        events := 0
+        for i, r := range server.requests {
+            if r.Header.Get("Authorization") != "Splunk secret" {
+                t.Errorf("%s: got Authorization %q", test.name, r.Header.Get("Authorization"))
+            }
+            if r.Header.Get("X-Splunk-Request-Channel") == "" {
+                t.Errorf("%s: no channel", test.name)
+            }
+            switch r.URL.Path {
+            case splunkEventPath:
+                events++
+            case splunkAckPath:
+                if body := string(server.bodies[i]); body != `{"acks":[7]}` {
+                    t.Errorf("%s: got ack request %s", test.name, body)
+                }
+            default:
+                t.Errorf("%s: posted to %s", test.name, r.URL.Path)
+            }
+        }
+        if events != test.events {
+            t.Errorf("%s: sent %d times, expected %d", test.name, events, test.events)
+        }
+    }
+}
+
+func TestSplunkSinkWithoutAckId(t *testing.T) {
+    server := newTestServer(func(r *http.Request, body []byte) (int, string) {
+        return http.StatusOK, `{"text":"Success","code":0}`
+    })
+    defer server.Close()
+    sink := openTestSink(t, openSplunkSink, "splunk+"+server.URL+"?token=secret&ack=true")
+    defer sink.close()
+    err := sink.(*splunkSink).send([]*logMessage{{msg: "one", time: testTime}})
+    if _, ok := err.(permanentError); !ok || !strings.Contains(err.Error(), "no ackId") {
+        t.Errorf("got %v, expected a permanent error", err)
+    }
+}
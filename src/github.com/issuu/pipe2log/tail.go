@@ -0,0 +1,397 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "io"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// With -tail pipe2log follows files like 'tail -F' instead of reading
+// from a pipe, i.e.
+//   -tail '/var/log/legacy/*.log,/var/log/daemon.log' -tailstate /var/lib/pipe2log/tail.json
+// The globs are expanded again on every poll, so new files are picked up.
+// A renamed or removed file is read to the end before its replacement is
+// opened, a truncated file is read again from the start. Files are
+// followed by their inode, a file renamed to a name matching the globs
+// too, like app.log.1, goes on where it was. The offsets of the processed
+// messages are saved in the state file, so a restart resumes where it
+// stopped. Files without a saved offset that exist at start are read from
+// the end, files appearing later from the start.
+
+var flagTail string
+var flagTailState string
+
+// how often the files are checked for new data
+const tailPollInterval = time.Second
+
+// tailPosition is where a file has been read up to
+type tailPosition struct {
+    Path string `json:"path"`
+    Inode uint64 `json:"inode"`
+    Offset int64 `json:"offset"`
+}
+
+// tailState has the positions of the processed messages by inode, so a
+// file keeps its position when it is renamed, updated by the main loop and
+// saved by the tailer.
+type tailState struct {
+    path string
+    mutex sync.Mutex
+    positions map[uint64]tailPosition
+    changed bool
+}
+
+func loadTailState(path string) (*tailState, error) {
+    state := &tailState{path: path, positions: make(map[uint64]tailPosition)}
+    if path == "" {
+        return state, nil
+    }
+    content, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return state, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var positions []tailPosition
+    if err = json.Unmarshal(content, &positions); err != nil {
+        return nil, err
+    }
+    for _, pos := range positions {
+        state.positions[pos.Inode] = pos
+    }
+    return state, nil
+}
+
+// get returns the position of the file with inode, or else of an older
+// file with path, false if neither has been seen before
+func (state *tailState) get(path string, inode uint64) (tailPosition, bool) {
+    state.mutex.Lock()
+    defer state.mutex.Unlock()
+    if pos, ok := state.positions[inode]; ok {
+        return pos, true
+    }
+    for _, pos := range state.positions {
+        if pos.Path == path {
+            return pos, true
+        }
+    }
+    return tailPosition{}, false
+}
+
+func (state *tailState) set(pos tailPosition) {
+    state.mutex.Lock()
+    defer state.mutex.Unlock()
+    state.positions[pos.Inode] = pos
+    state.changed = true
+}
+
+// update sets the offset of a file still followed, under the name it has
+// now
+func (state *tailState) update(pos tailPosition) {
+    state.mutex.Lock()
+    defer state.mutex.Unlock()
+    if old, ok := state.positions[pos.Inode]; ok {
+        old.Offset = pos.Offset
+        state.positions[pos.Inode] = old
+        state.changed = true
+    }
+}
+
+// rename sets the name the file with inode has now
+func (state *tailState) rename(inode uint64, path string) {
+    state.mutex.Lock()
+    defer state.mutex.Unlock()
+    if old, ok := state.positions[inode]; ok {
+        old.Path = path
+        state.positions[inode] = old
+        state.changed = true
+    }
+}
+
+func (state *tailState) remove(inode uint64) {
+    state.mutex.Lock()
+    defer state.mutex.Unlock()
+    delete(state.positions, inode)
+    state.changed = true
+}
+
+// retain forgets the files that aren't followed anymore
+func (state *tailState) retain(files map[string]*tailFile) {
+    state.mutex.Lock()
+    defer state.mutex.Unlock()
+    followed := make(map[uint64]bool)
+    for _, f := range files {
+        followed[f.inode] = true
+    }
+    for inode := range state.positions {
+        if !followed[inode] {
+            delete(state.positions, inode)
+            state.changed = true
+        }
+    }
+}
+
+// save writes the state file if anything changed, to a temporary file
+// renamed over the old one so a crash never leaves half a state file.
+func (state *tailState) save() error {
+    state.mutex.Lock()
+    if state.path == "" || !state.changed {
+        state.mutex.Unlock()
+        return nil
+    }
+    var positions tailPositions
+    for _, pos := range state.positions {
+        positions = append(positions, pos)
+    }
+    sort.Sort(positions)
+    state.changed = false
+    state.mutex.Unlock()
+
+    content, err := json.MarshalIndent(positions, "", "  ")
+    if err != nil {
+        return err
+    }
+    tmp := state.path + ".tmp"
+    if err = ioutil.WriteFile(tmp, append(content, '\n'), 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, state.path)
+}
+
+// tailPositions sort by path and inode
+type tailPositions []tailPosition
+
+func (p tailPositions) Len() int {
+    return len(p)
+}
+
+func (p tailPositions) Less(i, j int) bool {
+    return p[i].Path < p[j].Path || p[i].Path == p[j].Path && p[i].Inode < p[j].Inode
+}
+
+func (p tailPositions) Swap(i, j int) {
+    p[i], p[j] = p[j], p[i]
+}
+
+// tailFile is a followed file, data is read but not yet split into
+// messages.
+type tailFile struct {
+    path string
+    file *os.File
+    inode uint64
+    offset int64
+    data []byte
+}
+
+func fileInode(info os.FileInfo) uint64 {
+    if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+        return uint64(stat.Ino)
+    }
+    return 0
+}
+
+// tailer follows the files matching the globs and sends their messages
+// to dc.
+type tailer struct {
+    globs []string
+    state *tailState
+    dc chan scandata
+    files map[string]*tailFile
+    stop chan struct{}
+    done sync.WaitGroup
+}
+
+func startTailer(globs string, state *tailState, dc chan scandata) (*tailer, error) {
+    t := &tailer{globs: splitList(globs), state: state, dc: dc, files: make(map[string]*tailFile), stop: make(chan struct{})}
+    for _, glob := range t.globs {
+        // only to check the pattern
+        if _, err := filepath.Match(glob, ""); err != nil {
+            return nil, err
+        }
+    }
+    t.done.Add(1)
+    go t.run()
+    return t, nil
+}
+
+func (t *tailer) run() {
+    defer t.done.Done()
+    t.poll(true)
+    ticker := time.NewTicker(tailPollInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <- t.stop:
+            for _, f := range t.files {
+                f.file.Close()
+            }
+            return
+        case <- ticker.C:
+            t.poll(false)
+            if err := t.state.save(); err != nil {
+                log.Printf("%s tail state %s: %s\n", appTagVersion, t.state.path, err)
+            }
+        }
+    }
+}
+
+// close stops following the files, the state is saved by the caller
+// when the messages sent are processed.
+func (t *tailer) close() {
+    close(t.stop)
+    t.done.Wait()
+}
+
+// poll reads new data from the followed files and opens new ones
+func (t *tailer) poll(starting bool) {
+    matched := make(map[string]bool)
+    // the matched names of the inodes
+    names := make(map[uint64]string)
+    for _, glob := range t.globs {
+        paths, _ := filepath.Glob(glob)
+        for _, path := range paths {
+            matched[path] = true
+            if info, err := os.Stat(path); err == nil {
+                names[fileInode(info)] = path
+            }
+        }
+    }
+    files := make(map[string]*tailFile)
+    for path, f := range t.files {
+        t.read(f)
+        info, err := os.Stat(path)
+        if err == nil && matched[path] && fileInode(info) == f.inode {
+            if info.Size() < f.offset {
+                log.Printf("%s tail %s: file truncated, reading from the start\n", appTagVersion, path)
+                f.file.Seek(0, io.SeekStart)
+                f.offset = 0
+                f.data = nil
+                t.read(f)
+            }
+            files[path] = f
+            continue
+        }
+        if name, ok := names[f.inode]; ok {
+            // renamed to a name we follow too, like app.log.1
+            f.path = name
+            files[name] = f
+            t.state.rename(f.inode, name)
+            continue
+        }
+        // removed or rotated, everything written to it has been read, a
+        // new file is opened below
+        t.flush(f)
+        f.file.Close()
+        t.state.remove(f.inode)
+    }
+    t.files = files
+    for path := range matched {
+        if _, ok := t.files[path]; !ok {
+            t.open(path, starting)
+        }
+    }
+    // the files removed while we were stopped
+    if starting {
+        t.state.retain(t.files)
+    }
+}
+
+func (t *tailer) open(path string, starting bool) {
+    file, err := os.Open(path)
+    if err != nil {
+        log.Printf("%s tail %s: %s\n", appTagVersion, path, err)
+        return
+    }
+    info, err := file.Stat()
+    if err != nil || !info.Mode().IsRegular() {
+        file.Close()
+        return
+    }
+    f := &tailFile{path: path, file: file, inode: fileInode(info)}
+    // another name of a file already followed
+    for _, other := range t.files {
+        if other.inode == f.inode {
+            file.Close()
+            return
+        }
+    }
+    if pos, ok := t.state.get(path, f.inode); ok && pos.Inode == f.inode && pos.Offset <= info.Size() {
+        f.offset = pos.Offset
+    } else if starting && !ok {
+        f.offset = info.Size()
+    }
+    if f.offset > 0 {
+        if _, err = file.Seek(f.offset, io.SeekStart); err != nil {
+            file.Close()
+            return
+        }
+    }
+    t.state.set(tailPosition{Path: path, Inode: f.inode, Offset: f.offset})
+    t.files[path] = f
+    t.read(f)
+}
+
+// read reads until the end of the file and sends the complete messages
+func (t *tailer) read(f *tailFile) {
+    buf := make([]byte, 64*1024)
+    for {
+        n, err := f.file.Read(buf)
+        if n > 0 {
+            f.data = append(f.data, buf[:n]...)
+            if !t.split(f, false) {
+                return
+            }
+        }
+        if err != nil {
+            if err != io.EOF {
+                log.Printf("%s tail %s: %s\n", appTagVersion, f.path, err)
+            }
+            return
+        }
+    }
+}
+
+// flush sends what is left of a file that won't grow anymore
+func (t *tailer) flush(f *tailFile) {
+    t.split(f, true)
+}
+
+// split sends the messages in the data read, false when stopped
+func (t *tailer) split(f *tailFile, atEOF bool) bool {
+    for len(f.data) > 0 {
+        advance, token, err := scanSplit(f.data, atEOF)
+        if err != nil || advance == 0 && len(f.data) < bufio.MaxScanTokenSize {
+            return true
+        }
+        if advance == 0 {
+            // no end in sight, send it as it is
+            advance = len(f.data)
+            token = f.data
+        }
+        f.offset += int64(advance)
+        if token != nil {
+            data := make([]byte, len(token))
+            copy(data, token)
+            // the main loop may be gone
+            select {
+            case t.dc <- scandata{data: data, pos: &tailPosition{Path: f.path, Inode: f.inode, Offset: f.offset}}:
+            case <- t.stop:
+                return false
+            }
+        } else {
+            t.state.update(tailPosition{Path: f.path, Inode: f.inode, Offset: f.offset})
+        }
+        f.data = f.data[advance:]
+    }
+    f.data = nil
+    return true
+}
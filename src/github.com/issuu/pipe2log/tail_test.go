@@ -0,0 +1,178 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func newTestTailer(state *tailState, globs ...string) *tailer {
+    return &tailer{globs: globs, state: state, dc: make(chan scandata, 100), files: make(map[string]*tailFile), stop: make(chan struct{})}
+}
+
+// received returns the lines sent since the last call, the positions are
+// processed like by the main loop
+func (t *tailer) received() string {
+    var lines []string
+    for {
+        select {
+        case data := <-t.dc:
+            lines = append(lines, string(data.data))
+            t.state.update(*data.pos)
+        default:
+            return strings.Join(lines, ",")
+        }
+    }
+}
+
+func (t *tailer) closeFiles() {
+    for _, f := range t.files {
+        f.file.Close()
+    }
+}
+
+func appendTestFile(t *testing.T, path, content string) {
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.WriteString(content)
+    f.Close()
+}
+
+func TestTailRotate(t *testing.T) {
+    // with and without the rotated file matching the glob
+    for _, glob := range []string{"app.log", "app.log*"} {
+        dir, err := ioutil.TempDir("", "pipe2log")
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+        path := filepath.Join(dir, "app.log")
+        appendTestFile(t, path, "old\n")
+        state, _ := loadTailState("")
+        tail := newTestTailer(state, filepath.Join(dir, glob))
+        defer tail.closeFiles()
+
+        // existing files are read from the end
+        tail.poll(true)
+        appendTestFile(t, path, "one\ntwo\n")
+        tail.poll(false)
+        if got := tail.received(); got != "one,two" {
+            t.Errorf("%s: got %s, expected one,two", glob, got)
+        }
+
+        appendTestFile(t, path, "three\n")
+        os.Rename(path, path+".1")
+        appendTestFile(t, path, "four\n")
+        tail.poll(false)
+        if got := tail.received(); got != "three,four" {
+            t.Errorf("%s: got %s after rotating, expected three,four", glob, got)
+        }
+        appendTestFile(t, path+".1", "late\n")
+        tail.poll(false)
+        expected := ""
+        if glob == "app.log*" {
+            expected = "late"
+        }
+        if got := tail.received(); got != expected {
+            t.Errorf("%s: got %q from the rotated file, expected %q", glob, got, expected)
+        }
+        if len(state.positions) != len(tail.files) {
+            t.Errorf("%s: got %v for files %v", glob, state.positions, tail.files)
+        }
+    }
+}
+
+func TestTailTruncate(t *testing.T) {
+    dir, err := ioutil.TempDir("", "pipe2log")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "app.log")
+    state, _ := loadTailState("")
+    tail := newTestTailer(state, path)
+    defer tail.closeFiles()
+
+    // files appearing later are read from the start
+    tail.poll(true)
+    appendTestFile(t, path, "one\ntwo\n")
+    tail.poll(false)
+    tail.received()
+    os.Truncate(path, 0)
+    appendTestFile(t, path, "new\n")
+    tail.poll(false)
+    if got := tail.received(); got != "new" {
+        t.Errorf("got %s after truncating, expected new", got)
+    }
+}
+
+func TestTailResume(t *testing.T) {
+    dir, err := ioutil.TempDir("", "pipe2log")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "app.log")
+    statePath := filepath.Join(dir, "state.json")
+    glob := filepath.Join(dir, "*.log*")
+    state, _ := loadTailState(statePath)
+    tail := newTestTailer(state, glob)
+    tail.poll(true)
+    appendTestFile(t, path, "one\ntwo\n")
+    tail.poll(false)
+    // three is read but not processed before stopping
+    appendTestFile(t, path, "three\n")
+    tail.poll(false)
+    for i := 0; i < 2; i++ {
+        data := <-tail.dc
+        state.update(*data.pos)
+    }
+    tail.closeFiles()
+    if err := state.save(); err != nil {
+        t.Fatal(err)
+    }
+
+    // rotated while stopped
+    appendTestFile(t, path, "four\n")
+    os.Rename(path, path+".1")
+    appendTestFile(t, path, "five\n")
+    state, err = loadTailState(statePath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tail = newTestTailer(state, glob)
+    defer tail.closeFiles()
+    tail.poll(true)
+    got := strings.Split(tail.received(), ",")
+    if len(got) != 3 || got[0] == got[1] {
+        t.Fatalf("got %v after restarting, expected three, four and five", got)
+    }
+    // the order of the files isn't fixed
+    if strings.Join(got, ",") != "three,four,five" && strings.Join(got, ",") != "five,three,four" {
+        t.Errorf("got %v after restarting, expected three, four and five", got)
+    }
+}
+
+func TestTailStateForgetsRemovedFiles(t *testing.T) {
+    dir, err := ioutil.TempDir("", "pipe2log")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    state, _ := loadTailState("")
+    state.set(tailPosition{Path: filepath.Join(dir, "gone.log"), Inode: 1, Offset: 10})
+    path := filepath.Join(dir, "app.log")
+    appendTestFile(t, path, "one\n")
+    tail := newTestTailer(state, filepath.Join(dir, "*.log"))
+    defer tail.closeFiles()
+    tail.poll(true)
+    os.Remove(path)
+    tail.poll(false)
+    if len(state.positions) != 0 {
+        t.Errorf("got %v, expected no positions", state.positions)
+    }
+}
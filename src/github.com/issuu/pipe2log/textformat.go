@@ -0,0 +1,159 @@
+package main
+
+import (
+    "regexp"
+    "strings"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+// Text log layouts of common logging libraries are parsed with regular
+// expressions with named groups, the severity, time, msg and pid groups
+// make the message and the other groups are added as fields, i.e. logger.
+//   python: WARNING:root:disk almost full
+//   log4j:  2019-01-02 15:04:05,123 [main] WARN com.example.Disk - disk almost full
+//   glog:   W0102 15:04:05.123456    1234 disk.go:42] disk almost full
+//   rails:  W, [2019-01-02T15:04:05.123456 #1234]  WARN -- disk: disk almost full
+// Lines not matching the layout are scanned for a severity like the
+// default logformat.
+
+// lineParser parses lines with the first matching pattern
+type lineParser struct {
+    patterns []*regexp.Regexp
+    // layouts of the time group, without a year the current year is used
+    timeLayouts []string
+    // uppercase severity group values, other values are looked up as
+    // level names
+    severities map[string]syslog.Priority
+}
+
+var glogSeverities = map[string]syslog.Priority{
+    "I": syslog.LOG_INFO,
+    "W": syslog.LOG_WARNING,
+    "E": syslog.LOG_ERR,
+    "F": syslog.LOG_CRIT,
+}
+
+// the text formats known by name
+var textFormats = map[string]*lineParser{
+    "python": &lineParser{
+        patterns: []*regexp.Regexp{
+            // the default basicConfig format
+            regexp.MustCompile(`^(?P<severity>DEBUG|INFO|WARNING|ERROR|CRITICAL):(?P<logger>[^:]*):(?P<msg>.*)$`),
+            // '%(asctime)s - %(name)s - %(levelname)s - %(message)s'
+            regexp.MustCompile(`^(?P<time>\d{4}-\d\d-\d\d \d\d:\d\d:\d\d(?:,\d+)?) - (?P<logger>\S+) - (?P<severity>DEBUG|INFO|WARNING|ERROR|CRITICAL) - (?P<msg>.*)$`),
+        },
+        timeLayouts: []string{"2006-01-02 15:04:05.999999999"},
+    },
+    "log4j": &lineParser{
+        patterns: []*regexp.Regexp{
+            regexp.MustCompile(`^(?P<time>(?:\d{4}-\d\d-\d\d[ T])?\d\d:\d\d:\d\d(?:[,.]\d+)?) +\[(?P<thread>[^\]]*)\] +(?P<severity>TRACE|DEBUG|INFO|WARN|ERROR|FATAL) +(?P<logger>\S+) +- (?P<msg>.*)$`),
+        },
+        timeLayouts: []string{"2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999", "15:04:05.999999999"},
+    },
+    "glog": &lineParser{
+        patterns: []*regexp.Regexp{
+            regexp.MustCompile(`^(?P<severity>[IWEF])(?P<time>\d{4} \d\d:\d\d:\d\d\.\d+) +(?P<thread>\d+) (?P<source>[^ \]]+)\] (?P<msg>.*)$`),
+        },
+        timeLayouts: []string{"0102 15:04:05.999999999"},
+        severities: glogSeverities,
+    },
+    "rails": &lineParser{
+        patterns: []*regexp.Regexp{
+            regexp.MustCompile(`^[DIWEFA], \[(?P<time>[^ \]]+) #(?P<pid>\d+)\] +(?P<severity>[A-Z]+) -- (?P<logger>[^:]*): (?P<msg>.*)$`),
+        },
+        timeLayouts: []string{"2006-01-02T15:04:05.999999999"},
+    },
+}
+
+// klog is the kubernetes fork of glog
+func init() {
+    textFormats["klog"] = textFormats["glog"]
+}
+
+func textLogformat(format string) bool {
+    _, ok := textFormats[format]
+    return ok
+}
+
+// withCurrentYear sets the year of a time without one, a time in the
+// future is from last year
+func withCurrentYear(t time.Time) time.Time {
+    now := time.Now()
+    t = t.AddDate(now.Year()-t.Year(), 0, 0)
+    if t.After(now.Add(24 * time.Hour)) {
+        t = t.AddDate(-1, 0, 0)
+    }
+    return t
+}
+
+func (p *lineParser) parseTime(value string) (time.Time, bool) {
+    // a comma before the fraction of a second, i.e. python and log4j
+    value = strings.Replace(value, ",", ".", 1)
+    for _, layout := range p.timeLayouts {
+        t, err := time.ParseInLocation(layout, value, time.Local)
+        if err != nil {
+            continue
+        }
+        if t.Year() == 0 {
+            if strings.HasPrefix(layout, "15:") {
+                // only the time of day, it is today
+                now := time.Now()
+                t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+            } else {
+                t = withCurrentYear(t)
+            }
+        }
+        return t, true
+    }
+    return time.Time{}, false
+}
+
+// parse parses a line, nil if no pattern matches
+func (p *lineParser) parse(data []byte) (*logMessage, bool) {
+    line := string(data)
+    for _, re := range p.patterns {
+        rs := re.FindStringSubmatch(line)
+        if rs == nil {
+            continue
+        }
+        // without a msg group the line is the message
+        lm := &logMessage{severity: syslog.LOG_INFO, msg: line}
+        found := false
+        for i, name := range re.SubexpNames() {
+            value := rs[i]
+            switch name {
+            case "":
+            case "severity":
+                var severity syslog.Priority
+                var ok bool
+                if severity, ok = p.severities[strings.ToUpper(value)]; !ok {
+                    severity, ok = levelSeverity(value)
+                }
+                if ok {
+                    lm.severity = severity
+                    found = true
+                }
+            case "time":
+                if t, ok := p.parseTime(value); ok {
+                    lm.time = t
+                }
+            case "msg":
+                lm.msg = value
+            case "pid":
+                lm.procid = value
+            default:
+                if value == "" {
+                    continue
+                }
+                if lm.fields == nil {
+                    lm.fields = make(map[string]interface{})
+                }
+                lm.fields[name] = strings.TrimSpace(value)
+            }
+        }
+        return lm, found
+    }
+    return nil, false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    syslog "github.com/issuu/srslog"
+)
+
+func TestTextFormats(t *testing.T) {
+    year := time.Now().Year()
+    now := time.Now()
+    tests := []struct {
+        format string
+        line string
+        severity syslog.Priority
+        found bool
+        msg string
+        time time.Time
+        procid string
+        fields map[string]interface{}
+    }{
+        {"python", "WARNING:root:disk almost full", syslog.LOG_WARNING, true, "disk almost full", time.Time{}, "",
+            map[string]interface{}{"logger": "root"}},
+        {"python", "2019-01-02 15:04:05,123 - app.db - CRITICAL - connection lost", syslog.LOG_CRIT, true, "connection lost",
+            time.Date(2019, 1, 2, 15, 4, 5, 123000000, time.Local), "", map[string]interface{}{"logger": "app.db"}},
+        {"log4j", "2019-01-02 15:04:05,123 [main] WARN com.example.Disk - disk almost full", syslog.LOG_WARNING, true, "disk almost full",
+            time.Date(2019, 1, 2, 15, 4, 5, 123000000, time.Local), "", map[string]interface{}{"thread": "main", "logger": "com.example.Disk"}},
+        {"log4j", "2019-01-02T15:04:05.123 [pool-1 thread-2] FATAL Main - out of memory", syslog.LOG_CRIT, true, "out of memory",
+            time.Date(2019, 1, 2, 15, 4, 5, 123000000, time.Local), "", map[string]interface{}{"thread": "pool-1 thread-2", "logger": "Main"}},
+        {"log4j", "15:04:05.123 [main] TRACE Main - tick", syslog.LOG_DEBUG, true, "tick",
+            time.Date(now.Year(), now.Month(), now.Day(), 15, 4, 5, 123000000, time.Local), "", map[string]interface{}{"thread": "main", "logger": "Main"}},
+        {"glog", "E0102 15:04:05.123456    1234 disk.go:42] disk full", syslog.LOG_ERR, true, "disk full",
+            withCurrentYear(time.Date(year, 1, 2, 15, 4, 5, 123456000, time.Local)), "", map[string]interface{}{"thread": "1234", "source": "disk.go:42"}},
+        {"klog", "I0102 15:04:05.123456       1 main.go:10] started", syslog.LOG_INFO, true, "started",
+            withCurrentYear(time.Date(year, 1, 2, 15, 4, 5, 123456000, time.Local)), "", map[string]interface{}{"thread": "1", "source": "main.go:10"}},
+        {"rails", "W, [2019-01-02T15:04:05.123456 #1234]  WARN -- disk: disk almost full", syslog.LOG_WARNING, true, "disk almost full",
+            time.Date(2019, 1, 2, 15, 4, 5, 123456000, time.Local), "1234", map[string]interface{}{"logger": "disk"}},
+        // a level that isn't a severity
+        {"rails", "A, [2019-01-02T15:04:05.123456 #1234]   ANY -- : unknown", syslog.LOG_INFO, false, "unknown",
+            time.Date(2019, 1, 2, 15, 4, 5, 123456000, time.Local), "1234", nil},
+    }
+    for _, test := range tests {
+        lm, found := textFormats[test.format].parse([]byte(test.line))
+        if lm == nil {
+            t.Errorf("%s %s: not parsed", test.format, test.line)
+            continue
+        }
+        if lm.severity != test.severity || found != test.found || lm.msg != test.msg || !lm.time.Equal(test.time) ||
+            lm.procid != test.procid || len(lm.fields) != len(test.fields) {
+            t.Errorf("%s %s: got %d %v %q %s %q %v", test.format, test.line, lm.severity, found, lm.msg, lm.time, lm.procid, lm.fields)
+            continue
+        }
+        for key, value := range test.fields {
+            if lm.fields[key] != value {
+                t.Errorf("%s %s: got field %s %v, expected %v", test.format, test.line, key, lm.fields[key], value)
+            }
+        }
+    }
+}
+
+func TestTextFormatsNotMatching(t *testing.T) {
+    tests := []struct {
+        format string
+        line string
+    }{
+        {"python", "NOTICE:root:not a python level"},
+        {"log4j", "2019-01-02 15:04:05,123 WARN no thread - x"},
+        {"glog", "X0102 15:04:05.123456 1 a.go:1] unknown severity letter"},
+        {"rails", "W, [2019-01-02T15:04:05.123456] WARN -- no pid"},
+    }
+    for _, test := range tests {
+        if lm, _ := textFormats[test.format].parse([]byte(test.line)); lm != nil {
+            t.Errorf("%s %s: got %+v", test.format, test.line, lm)
+        }
+    }
+}
+
+func TestParseLogLineTextFormat(t *testing.T) {
+    _, restore := useTestPipeline(t)
+    defer restore()
+    // lines not in the layout are scanned for a severity
+    lm, found := parseLogLine("log4j", []byte("ERROR: not a log4j line"))
+    if lm == nil || !found || lm.severity != syslog.LOG_ERR {
+        t.Errorf("got %+v", lm)
+    }
+    // the default severity for a level that isn't one
+    lm, found = parseLogLine("rails", []byte("A, [2019-01-02T15:04:05.123456 #1]   ANY -- : x"))
+    if lm == nil || found || lm.severity != syslog.LOG_INFO {
+        t.Errorf("got %+v", lm)
+    }
+    if textLogformat("logback") || lineParserFor("logback") != nil {
+        t.Errorf("logback is not a text format")
+    }
+}
+
+func TestWithCurrentYear(t *testing.T) {
+    now := time.Now()
+    if got := withCurrentYear(time.Date(0, now.Month(), now.Day(), 1, 2, 3, 0, time.Local)); got.Year() != now.Year() {
+        t.Errorf("got %s for today", got)
+    }
+    // a time more than a day ahead is from last year
+    ahead := now.AddDate(0, 0, 2)
+    if got := withCurrentYear(time.Date(0, ahead.Month(), ahead.Day(), 0, 0, 0, 0, time.Local)); !got.Before(now) {
+        t.Errorf("got %s for a date ahead", got)
+    }
+}